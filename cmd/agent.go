@@ -1,33 +1,123 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"image"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/rviscarra/webrtc-remote-screen/internal/api"
+	"github.com/rviscarra/webrtc-remote-screen/internal/auth"
 	"github.com/rviscarra/webrtc-remote-screen/internal/encoders"
+	"github.com/rviscarra/webrtc-remote-screen/internal/raudio"
 	"github.com/rviscarra/webrtc-remote-screen/internal/rdisplay"
 	"github.com/rviscarra/webrtc-remote-screen/internal/rtc"
+	"github.com/rviscarra/webrtc-remote-screen/internal/tlsutil"
 )
 
 const (
 	httpDefaultPort   = "9000"
 	defaultStunServer = "stun:stun.l.google.com:19302"
+	defaultMinBitrate = 100
+	defaultMaxBitrate = 4000
+	defaultMaxFPS     = 30
+	defaultIdleFPS    = 1
+	defaultShareTTL   = time.Hour
+	authRealm         = "webrtc-remote-screen"
+	defaultCertFile   = "agent.crt"
+	defaultKeyFile    = "agent.key"
 )
 
+// newVideoProvider creates the provider of the video source, capture selects
+// how an X server is captured
+func newVideoProvider(source, capture string) (rdisplay.Service, error) {
+	switch {
+	case source == "x11" && capture == "screenshot":
+		return rdisplay.NewVideoProvider()
+	case source == "x11" && capture == "xshm":
+		return rdisplay.NewXShmVideoProvider()
+	case source == "x11":
+		return nil, fmt.Errorf("Unknown capture method %q", capture)
+	case source == "testsrc" || strings.HasPrefix(source, "testsrc:"):
+		var size image.Point
+		if source != "testsrc" {
+			_, err := fmt.Sscanf(strings.TrimPrefix(source, "testsrc:"), "%dx%d", &size.X, &size.Y)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid test pattern size %q", source)
+			}
+		}
+		return rdisplay.NewTestPatternProvider(size)
+	case strings.HasSuffix(strings.ToLower(source), ".y4m"):
+		return rdisplay.NewY4MProvider(source)
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("Unknown video source %q", source)
+	}
+	return rdisplay.NewPNGSequenceProvider(source)
+}
+
 func main() {
 
 	httpPort := flag.String("http.port", httpDefaultPort, "HTTP listen port")
 	stunServer := flag.String("stun.server", defaultStunServer, "STUN server URL (stun:)")
+	enableInput := flag.Bool("input.enabled", true, "Allow remote mouse and keyboard control")
+	minBitrate := flag.Int("bitrate.min", defaultMinBitrate, "Minimum video bitrate (kbps)")
+	maxBitrate := flag.Int("bitrate.max", defaultMaxBitrate, "Maximum video bitrate (kbps)")
+	maxFPS := flag.Int("video.fps.max", defaultMaxFPS, "Maximum frame rate the clients can request")
+	videoSource := flag.String("video.source", "x11", "Video source: x11, testsrc[:WIDTHxHEIGHT], the path of a Y4M file or a directory of PNG files")
+	videoCapture := flag.String("video.capture", "screenshot", "Screen capture method: screenshot or xshm (MIT-SHM, faster)")
+	videoCodecs := flag.String("video.codecs", "vp8,h264,vp9,av1", "Video codec preference order, the codecs left out are never used")
+	idleFPS := flag.Int("video.fps.idle", defaultIdleFPS, "Frame rate while the screen doesn't change, 0 encodes every frame")
+	recordDir := flag.String("record.dir", "", "Directory for the session recordings, enables recording")
+	audioSource := flag.String("audio.source", "", "Audio source: pulse (default output monitor), tone or the path of a WAV file, disabled by default")
+	audioDevice := flag.String("audio.device", "", "PulseAudio source to capture instead of the default output monitor")
+	recordAll := flag.Bool("record.all", false, "Record every session (requires record.dir)")
+	authTokens := flag.String("auth.tokens", "", "Comma separated list of accepted bearer tokens")
+	authHtpasswd := flag.String("auth.htpasswd", "", "htpasswd file with the basic auth users (bcrypt or SHA1)")
+	shareSecret := flag.String("auth.share.secret", "", "Secret used to sign share links, enables them")
+	shareTTL := flag.Duration("auth.share.ttl", defaultShareTTL, "Maximum validity of a share link")
+	tlsCert := flag.String("tls.cert", "", "TLS certificate file (PEM), enables HTTPS")
+	tlsKey := flag.String("tls.key", "", "TLS private key file (PEM)")
+	tlsSelfSigned := flag.Bool("tls.selfsigned", false, "Generate a self-signed certificate if neither tls.cert nor tls.key exist")
+	tlsHosts := flag.String("tls.hosts", "", "Comma separated list of extra host names / IPs for the self-signed certificate")
+	tlsClientCA := flag.String("tls.clientca", "", "CA bundle (PEM) used to verify client certificates, enables mTLS")
+	redirectPort := flag.String("http.redirect.port", "", "Port that redirects plain HTTP requests to HTTPS")
 	flag.Parse()
 
-	var video rdisplay.Service
-	video, err := rdisplay.NewVideoProvider()
+	if *minBitrate <= 0 || *maxBitrate < *minBitrate {
+		log.Fatalf("Invalid bitrate range %d-%d kbps", *minBitrate, *maxBitrate)
+	}
+	if *maxFPS <= 0 {
+		log.Fatalf("Invalid maximum frame rate %d", *maxFPS)
+	}
+	if *idleFPS < 0 {
+		log.Fatalf("Invalid idle frame rate %d", *idleFPS)
+	}
+	codecs, err := rtc.ParseCodecs(*videoCodecs)
+	if err != nil {
+		log.Fatalf("Invalid video.codecs: %v", err)
+	}
+	if *recordAll && *recordDir == "" {
+		log.Fatalf("record.all requires record.dir")
+	}
+	if *recordDir != "" {
+		if err := os.MkdirAll(*recordDir, 0750); err != nil {
+			log.Fatalf("Can't create the recordings directory: %v", err)
+		}
+	}
+
+	video, err := newVideoProvider(*videoSource, *videoCapture)
 	if err != nil {
 		log.Fatalf("Can't init video: %v", err)
 	}
@@ -40,14 +130,81 @@ func main() {
 	if err != nil {
 		log.Fatalf("Can't create encoder service: %v", err)
 	}
+	if len(rtc.SupportedCodecs(enc, codecs)) == 0 {
+		log.Fatalf("None of the codecs in video.codecs (%s) is built in, see the encoders variable of the Makefile", *videoCodecs)
+	}
+
+	var audio raudio.Service
+	switch *audioSource {
+	case "":
+	case "pulse":
+		audio, err = raudio.NewPulseProvider(*audioDevice)
+	case "tone":
+		audio = raudio.NewToneProvider()
+	default:
+		audio, err = raudio.NewWAVProvider(*audioSource)
+	}
+	if err != nil {
+		log.Fatalf("Can't init audio: %v", err)
+	}
+	if audio != nil && !enc.SupportsAudio() {
+		log.Fatalf("Audio requires the Opus encoder (build with encoders=...,opus)")
+	}
+
+	var input rdisplay.InputService
+	if *enableInput {
+		var supported bool
+		input, supported = video.(rdisplay.InputService)
+		if !supported {
+			log.Printf("The video source doesn't support remote input, the sessions will be view-only")
+		}
+	}
 
 	var webrtc rtc.Service
-	webrtc = rtc.NewRemoteScreenService(*stunServer, video, enc)
+	webrtc = rtc.NewRemoteScreenService(*stunServer, video, input, audio, enc, rtc.StreamLimits{
+		MaxFPS:  *maxFPS,
+		IdleFPS: *idleFPS,
+		Bitrate: rtc.BitrateLimits{
+			Min: *minBitrate * 1000,
+			Max: *maxBitrate * 1000,
+		},
+		Codecs: codecs,
+	}, rtc.RecordingOptions{
+		Dir: *recordDir,
+		All: *recordAll,
+	})
+
+	var authenticators []auth.Authenticator
+	if *authTokens != "" {
+		authenticators = append(authenticators, auth.NewTokenAuthenticator(strings.Split(*authTokens, ",")))
+	}
+	if *authHtpasswd != "" {
+		htpasswd, err := auth.NewHtpasswdAuthenticator(*authHtpasswd, authRealm)
+		if err != nil {
+			log.Fatalf("Can't load htpasswd file: %v", err)
+		}
+		authenticators = append(authenticators, htpasswd)
+	}
 
 	mux := http.NewServeMux()
 
+	if *shareSecret != "" {
+		if len(authenticators) == 0 {
+			log.Fatalf("Share links require another authentication method to issue them")
+		}
+		shareLinks := auth.NewShareLinkAuthenticator(*shareSecret, *shareTTL)
+		// Share link holders can't issue new links, and the API only lets
+		// them watch the screens (see auth.ScopeView)
+		mux.Handle("/api/share", auth.Middleware(auth.ShareLinkHandler(shareLinks), authenticators...))
+		authenticators = append(authenticators, shareLinks)
+	}
+	if len(authenticators) == 0 {
+		log.Printf("Warning: authentication is disabled, anyone who can reach the agent can watch the screen")
+	}
+
 	// Endpoint to create a new speech to text session
-	mux.Handle("/api/", http.StripPrefix("/api", api.MakeHandler(webrtc, video)))
+	apiHandler := http.StripPrefix("/api", api.MakeHandler(webrtc, video))
+	mux.Handle("/api/", auth.Middleware(apiHandler, authenticators...))
 
 	// Serve static assets
 	mux.Handle("/static/", http.StripPrefix("/static", http.FileServer(http.Dir("./web"))))
@@ -59,14 +216,58 @@ func main() {
 		http.ServeFile(w, r, "./web/index.html")
 	})
 
-	errors := make(chan error, 2)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%s", *httpPort),
+		Handler: mux,
+	}
+	if *tlsSelfSigned || *tlsCert != "" {
+		if *tlsSelfSigned && *tlsCert == "" {
+			*tlsCert = defaultCertFile
+		}
+		if *tlsSelfSigned && *tlsKey == "" {
+			*tlsKey = defaultKeyFile
+		}
+		var cert tls.Certificate
+		if *tlsSelfSigned {
+			var hosts []string
+			if *tlsHosts != "" {
+				hosts = strings.Split(*tlsHosts, ",")
+			}
+			cert, err = tlsutil.LoadOrCreateSelfSigned(*tlsCert, *tlsKey, hosts)
+		} else {
+			cert, err = tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		}
+		if err != nil {
+			log.Fatalf("Can't load TLS certificate: %v", err)
+		}
+		server.TLSConfig, err = tlsutil.NewServerConfig(cert, *tlsClientCA)
+		if err != nil {
+			log.Fatalf("Can't configure TLS: %v", err)
+		}
+	} else if *tlsClientCA != "" || *redirectPort != "" {
+		log.Fatalf("tls.clientca and http.redirect.port require TLS (tls.cert or tls.selfsigned)")
+	}
+
+	errors := make(chan error, 3)
 	go func() {
-		log.Printf("Starting signaling server on port %s", *httpPort)
-		errors <- http.ListenAndServe(fmt.Sprintf(":%s", *httpPort), mux)
+		if server.TLSConfig != nil {
+			log.Printf("Starting signaling server on port %s (HTTPS)", *httpPort)
+			errors <- server.ListenAndServeTLS("", "")
+		} else {
+			log.Printf("Starting signaling server on port %s", *httpPort)
+			errors <- server.ListenAndServe()
+		}
 	}()
 
+	if *redirectPort != "" {
+		go func() {
+			log.Printf("Redirecting HTTP requests on port %s to HTTPS", *redirectPort)
+			errors <- http.ListenAndServe(fmt.Sprintf(":%s", *redirectPort), tlsutil.RedirectHandler(*httpPort))
+		}()
+	}
+
 	go func() {
-		interrupt := make(chan os.Signal)
+		interrupt := make(chan os.Signal, 1)
 		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 		errors <- fmt.Errorf("Received %v signal", <-interrupt)
 	}()
@@ -3,15 +3,19 @@ module github.com/rviscarra/webrtc-remote-screen
 go 1.12
 
 require (
-	github.com/BurntSushi/xgb v0.0.0-20160522181843-27f122750802 // indirect
-	github.com/gen2brain/shm v0.0.0-20180314170312-6c18ff7f8b90 // indirect
+	github.com/BurntSushi/xgb v0.0.0-20160522181843-27f122750802
+	github.com/gen2brain/shm v0.0.0-20180314170312-6c18ff7f8b90
 	github.com/gen2brain/x264-go v0.0.0-20180306035800-58f586137654
 	github.com/google/uuid v1.1.1
 	github.com/kbinani/screenshot v0.0.0-20190612115439-c3c7d93696f3
 	github.com/lxn/win v0.0.0-20190618153233-9c04a4e8d0b8 // indirect
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
+	github.com/pion/rtcp v1.2.1
+	github.com/pion/rtp v1.1.3
 	github.com/pion/sdp v1.3.0
 	github.com/pion/webrtc/v2 v2.1.0
+	golang.org/x/crypto v0.0.0-20190404164418-38d8ce5564a5
+	golang.org/x/net v0.0.0-20190628185345-da137c7871d7
 	golang.org/x/sync v0.0.0-20190227155943-e225da77a7e6 // indirect
 	golang.org/x/text v0.3.1-0.20180807135948-17ff2d5776d2 // indirect
 )
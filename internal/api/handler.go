@@ -4,17 +4,176 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/rviscarra/webrtc-remote-screen/internal/auth"
 	"github.com/rviscarra/webrtc-remote-screen/internal/rdisplay"
 	"github.com/rviscarra/webrtc-remote-screen/internal/rtc"
 )
 
+// How long a trickle request waits for new server candidates
+const trickleWait = 2 * time.Second
+
 func handleError(w http.ResponseWriter, err error) {
+	if err == rtc.ErrSessionNotFound || err == rtc.ErrScreenNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err == rtc.ErrRecordingDisabled {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if err == rtc.ErrInvalidStreamOptions {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if mismatch, ok := err.(*rtc.CodecMismatchError); ok {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, newCodecErrorPayload(mismatch))
+		return
+	}
 	fmt.Printf("Error: %v", err)
 	w.WriteHeader(http.StatusInternalServerError)
 }
 
-// MakeHandler returns an HTTP handler for the session service
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	w.Write(payload)
+}
+
+func newCodecErrorPayload(err *rtc.CodecMismatchError) codecErrorPayload {
+	payload := codecErrorPayload{
+		Error:     "No common video codec",
+		Offered:   make([]offeredCodecPayload, len(err.Offered)),
+		Supported: err.Supported,
+	}
+	for i, codec := range err.Offered {
+		payload.Offered[i] = offeredCodecPayload{
+			Codec:       codec.Name,
+			PayloadType: codec.PayloadType,
+			Fmtp:        codec.Fmtp,
+			Rejected:    codec.Rejected,
+		}
+	}
+	return payload
+}
+
+func newSessionPayload(info rtc.SessionInfo) sessionPayload {
+	return sessionPayload{
+		ID:         info.ID,
+		State:      info.State,
+		Codec:      info.Codec,
+		Screen:     info.Screen,
+		StartedAt:  info.StartedAt,
+		RemoteAddr: info.RemoteAddr,
+		Recording:  info.Recording,
+		Frames: framesPayload{
+			Captured:  info.Frames.Captured,
+			Dropped:   info.Frames.Dropped,
+			Unchanged: info.Frames.Unchanged,
+			Encoded:   info.Frames.Encoded,
+			Latency:   info.Frames.Latency.Seconds() * 1000,
+		},
+	}
+}
+
+// handleRenegotiate makes the agent send a new offer to the session client,
+// only sessions signaled thru the WebSocket support it
+func handleRenegotiate(w http.ResponseWriter, r *http.Request, webrtc rtc.Service, id string) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	peer, err := webrtc.Connection(id)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	if err := peer.Renegotiate(); err != nil {
+		if err == rtc.ErrRenegotiationUnsupported {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		handleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleRecording starts (POST) or stops (DELETE) the session recording
+func handleRecording(w http.ResponseWriter, r *http.Request, webrtc rtc.Service, id string) {
+	peer, err := webrtc.Connection(id)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		path, err := peer.StartRecording()
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+		writeJSON(w, recordingResponse{
+			Path: path,
+		})
+	case http.MethodDelete:
+		if err := peer.StopRecording(); err != nil {
+			handleError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSwitchScreen changes the screen captured for the session
+func handleSwitchScreen(w http.ResponseWriter, r *http.Request, webrtc rtc.Service, id string) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	req := switchScreenRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	peer, err := webrtc.Connection(id)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	if err := peer.SwitchScreen(req.Screen); err != nil {
+		handleError(w, err)
+		return
+	}
+	writeJSON(w, newSessionPayload(peer.Info()))
+}
+
+// viewAllowed checks if a request can be made with auth.ScopeView: creating
+// a session and exchanging its candidates, and watching the screens
+func viewAllowed(r *http.Request) bool {
+	path := r.URL.Path
+	switch {
+	case path == "/session":
+		return r.Method == http.MethodPost
+	case strings.HasPrefix(path, "/session/"):
+		return r.Method == http.MethodPatch
+	case path == "/screens", strings.HasPrefix(path, "/screens/"):
+		return r.Method == http.MethodGet
+	}
+	return path == "/ws"
+}
+
+// MakeHandler returns an HTTP handler for the session service, the requests
+// authenticated with auth.ScopeView only get to watch the screens
 func MakeHandler(webrtc rtc.Service, display rdisplay.Service) http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/session", func(w http.ResponseWriter, r *http.Request) {
@@ -26,24 +185,28 @@ func MakeHandler(webrtc rtc.Service, display rdisplay.Service) http.Handler {
 		req := newSessionRequest{}
 
 		if err := dec.Decode(&req); err != nil {
-			handleError(w, err)
+			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		peer, err := webrtc.CreateRemoteScreenConnection(req.Screen, 20)
+		options := req.options()
+		options.ViewOnly = auth.RequestScope(r) == auth.ScopeView
+		peer, err := webrtc.CreateRemoteScreenConnection(req.Screen, options, r.RemoteAddr)
 		if err != nil {
 			handleError(w, err)
 			return
 		}
 
-		answer, err := peer.ProcessOffer(req.Offer)
+		answer, err := peer.ProcessOffer(req.Offer, req.Trickle)
 
 		if err != nil {
+			peer.Close()
 			handleError(w, err)
 			return
 		}
 
 		payload, err := json.Marshal(newSessionResponse{
+			ID:     peer.ID(),
 			Answer: answer,
 		})
 		if err != nil {
@@ -54,6 +217,116 @@ func MakeHandler(webrtc rtc.Service, display rdisplay.Service) http.Handler {
 		w.Write(payload)
 	})
 
+	// Trickle ICE, the client sends its candidates and gets back the ones
+	// gathered by the server since the previous request
+	mux.HandleFunc("/session/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/session/")
+		peer, err := webrtc.Connection(id)
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+
+		req := trickleRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, c := range req.Candidates {
+			err := peer.AddICECandidate(rtc.ICECandidate{
+				Candidate:     c.Candidate,
+				SDPMid:        c.SDPMid,
+				SDPMLineIndex: c.SDPMLineIndex,
+			})
+			if err != nil {
+				handleError(w, err)
+				return
+			}
+		}
+
+		// Only long-poll when the client has nothing else to send
+		wait := trickleWait
+		if len(req.Candidates) > 0 {
+			wait = 0
+		}
+		candidates, done, err := peer.LocalCandidates(wait)
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+		candidatesPayload := make([]candidatePayload, len(candidates))
+		for i, c := range candidates {
+			candidatesPayload[i] = candidatePayload{
+				Candidate:     c.Candidate,
+				SDPMid:        c.SDPMid,
+				SDPMLineIndex: c.SDPMLineIndex,
+			}
+		}
+		writeJSON(w, trickleResponse{
+			Candidates: candidatesPayload,
+			Done:       done,
+		})
+	})
+
+	mux.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		sessions := webrtc.Sessions()
+		sessionsPayload := make([]sessionPayload, len(sessions))
+		for i, info := range sessions {
+			sessionsPayload[i] = newSessionPayload(info)
+		}
+		writeJSON(w, sessionsResponse{
+			Sessions: sessionsPayload,
+		})
+	})
+
+	mux.HandleFunc("/sessions/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/sessions/")
+		if strings.HasSuffix(id, "/renegotiate") {
+			handleRenegotiate(w, r, webrtc, strings.TrimSuffix(id, "/renegotiate"))
+			return
+		}
+		if strings.HasSuffix(id, "/recording") {
+			handleRecording(w, r, webrtc, strings.TrimSuffix(id, "/recording"))
+			return
+		}
+		if strings.HasSuffix(id, "/screen") {
+			handleSwitchScreen(w, r, webrtc, strings.TrimSuffix(id, "/screen"))
+			return
+		}
+		if id == "" || strings.Contains(id, "/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			info, err := webrtc.Session(id)
+			if err != nil {
+				handleError(w, err)
+				return
+			}
+			writeJSON(w, newSessionPayload(info))
+		case http.MethodDelete:
+			if err := webrtc.CloseSession(id); err != nil {
+				handleError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Signaling over a WebSocket, needed for server initiated renegotiation
+	mux.Handle("/ws", makeSignalingHandler(webrtc))
+
 	mux.HandleFunc("/screens", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			w.WriteHeader(http.StatusMethodNotAllowed)
@@ -70,7 +343,17 @@ func MakeHandler(webrtc rtc.Service, display rdisplay.Service) http.Handler {
 		screensPayload := make([]screenPayload, len(screens))
 
 		for i, s := range screens {
-			screensPayload[i].Index = s.Index
+			screensPayload[i] = screenPayload{
+				Index:       s.Index,
+				X:           s.Bounds.Min.X,
+				Y:           s.Bounds.Min.Y,
+				Width:       s.Bounds.Dx(),
+				Height:      s.Bounds.Dy(),
+				Primary:     s.Primary,
+				Name:        s.Name,
+				RefreshRate: s.RefreshRate,
+				Scale:       s.Scale,
+			}
 		}
 		payload, err := json.Marshal(screensResponse{
 			Screens: screensPayload,
@@ -82,5 +365,31 @@ func MakeHandler(webrtc rtc.Service, display rdisplay.Service) http.Handler {
 
 		w.Write(payload)
 	})
-	return mux
+	mux.HandleFunc("/screens/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/screens/"), "/")
+		if len(parts) != 2 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		screenIx, err := strconv.Atoi(parts[0])
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch parts[1] {
+		case "snapshot":
+			handleSnapshot(w, r, display, screenIx)
+		case "mjpeg":
+			handleMJPEG(w, r, webrtc, screenIx)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth.RequestScope(r) == auth.ScopeView && !viewAllowed(r) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
 }
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rviscarra/webrtc-remote-screen/internal/auth"
+	"github.com/rviscarra/webrtc-remote-screen/internal/encoders"
+	"github.com/rviscarra/webrtc-remote-screen/internal/rdisplay"
+	"github.com/rviscarra/webrtc-remote-screen/internal/rtc"
+)
+
+const testToken = "admin-token"
+
+// newScopedService serves the API behind a token and a share link authenticator
+func newScopedService(t *testing.T) (*httptest.Server, rtc.Service, *auth.ShareLinkAuthenticator) {
+	t.Helper()
+	video, err := rdisplay.NewTestPatternProvider(loopbackScreen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := rtc.NewRemoteScreenService("", video, nil, nil, &fakeEncoderService{codec: encoders.VP8Codec}, rtc.StreamLimits{
+		MaxFPS: 30,
+		Bitrate: rtc.BitrateLimits{
+			Min: 100000,
+			Max: 4000000,
+		},
+	}, rtc.RecordingOptions{})
+	shareLinks := auth.NewShareLinkAuthenticator("secret", time.Hour)
+	handler := auth.Middleware(MakeHandler(service, video), auth.NewTokenAuthenticator([]string{testToken}), shareLinks)
+	return httptest.NewServer(handler), service, shareLinks
+}
+
+func scopedRequest(t *testing.T, method, target string, credentials url.Values, body string) int {
+	t.Helper()
+	req, err := http.NewRequest(method, target+"?"+credentials.Encode(), bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func TestShareLinkScope(t *testing.T) {
+	server, service, shareLinks := newScopedService(t)
+	defer server.Close()
+	peer, err := service.CreateRemoteScreenConnection(0, rtc.StreamOptions{}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	sessionPath := server.URL + "/sessions/" + peer.ID()
+	share := shareLinks.Sign(time.Now().Add(time.Minute))
+
+	tests := []struct {
+		method   string
+		path     string
+		body     string
+		expected int
+	}{
+		{http.MethodDelete, sessionPath, "", http.StatusForbidden},
+		{http.MethodPost, sessionPath + "/recording", "", http.StatusForbidden},
+		{http.MethodDelete, sessionPath + "/recording", "", http.StatusForbidden},
+		{http.MethodPost, sessionPath + "/screen", `{"screen": 0}`, http.StatusForbidden},
+		{http.MethodPost, sessionPath + "/renegotiate", "", http.StatusForbidden},
+		{http.MethodGet, server.URL + "/sessions", "", http.StatusForbidden},
+		{http.MethodGet, sessionPath, "", http.StatusForbidden},
+		// Watching is allowed, these get thru to the handlers
+		{http.MethodGet, server.URL + "/screens", "", http.StatusOK},
+		{http.MethodGet, server.URL + "/screens/0/snapshot", "", http.StatusOK},
+		{http.MethodPost, server.URL + "/session", "{", http.StatusBadRequest},
+		{http.MethodPatch, server.URL + "/session/" + peer.ID(), "{", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if status := scopedRequest(t, test.method, test.path, share, test.body); status != test.expected {
+			t.Errorf("%s %s with a share link got %d, expected %d", test.method, test.path, status, test.expected)
+		}
+	}
+	if _, err := service.Session(peer.ID()); err != nil {
+		t.Fatalf("The session was closed by a share link holder: %v", err)
+	}
+
+	admin := url.Values{"token": []string{testToken}}
+	if status := scopedRequest(t, http.MethodDelete, sessionPath, admin, ""); status != http.StatusNoContent {
+		t.Errorf("DELETE %s with a token got %d, expected 204", sessionPath, status)
+	}
+}
@@ -0,0 +1,550 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v2"
+	"github.com/rviscarra/webrtc-remote-screen/internal/encoders"
+	"github.com/rviscarra/webrtc-remote-screen/internal/rdisplay"
+	"github.com/rviscarra/webrtc-remote-screen/internal/rtc"
+)
+
+// Size of the frames sent by the fake encoders, large enough to be split
+// in several RTP packets
+const fakeFrameSize = 3000
+
+// fakeEncoderService creates encoders that don't compress anything, they
+// emit well formed VP8 or H.264 frames carrying the video size and a frame
+// counter so the pipeline can be tested without libvpx or x264
+type fakeEncoderService struct {
+	codec encoders.VideoCodec
+}
+
+func (s *fakeEncoderService) NewEncoder(codec encoders.VideoCodec, opts encoders.Options) (encoders.Encoder, error) {
+	if codec != s.codec {
+		return nil, fmt.Errorf("Unsupported codec %d", codec)
+	}
+	return &fakeEncoder{codec: codec, size: opts.Size, keyFrame: 1}, nil
+}
+
+func (s *fakeEncoderService) Supports(codec encoders.VideoCodec) bool {
+	return codec == s.codec
+}
+
+func (s *fakeEncoderService) NewAudioEncoder(sampleRate, channels int) (encoders.AudioEncoder, error) {
+	return nil, errors.New("No audio encoder")
+}
+
+func (s *fakeEncoderService) SupportsAudio() bool {
+	return false
+}
+
+type fakeEncoder struct {
+	codec    encoders.VideoCodec
+	size     image.Point
+	count    uint32
+	keyFrame int32
+}
+
+func (e *fakeEncoder) Encode(frame *image.RGBA) ([]byte, error) {
+	if frame.Rect.Size() != e.size {
+		return nil, fmt.Errorf("Got a %v frame, expected %v", frame.Rect.Size(), e.size)
+	}
+	key := atomic.SwapInt32(&e.keyFrame, 0) == 1
+	e.count++
+	if e.codec == encoders.VP8Codec {
+		return fakeVP8Frame(e.size, key, e.count), nil
+	}
+	return fakeH264Frame(e.size, key, e.count), nil
+}
+
+func (e *fakeEncoder) VideoSize() (image.Point, error) {
+	return e.size, nil
+}
+
+func (e *fakeEncoder) RequestKeyFrame() {
+	atomic.StoreInt32(&e.keyFrame, 1)
+}
+
+func (e *fakeEncoder) SetBitrate(bitrate int) error {
+	return nil
+}
+
+func (e *fakeEncoder) Close() error {
+	return nil
+}
+
+// filler returns the body of a fake frame, it never contains a start code
+func filler(count uint32) []byte {
+	body := bytes.Repeat([]byte{0xaa}, fakeFrameSize)
+	binary.BigEndian.PutUint32(body, count|0x80808080)
+	return body
+}
+
+// fakeVP8Frame builds a frame with a valid VP8 frame tag, keyframes have
+// the start code and dimensions (RFC 6386, 9.1)
+func fakeVP8Frame(size image.Point, key bool, count uint32) []byte {
+	// Version 0, shown, the first partition size is left at zero
+	tag := byte(0x10)
+	if !key {
+		tag |= 1
+	}
+	frame := []byte{tag, 0, 0}
+	if key {
+		frame = append(frame, 0x9d, 0x01, 0x2a,
+			byte(size.X), byte(size.X>>8), byte(size.Y), byte(size.Y>>8))
+	}
+	return append(frame, filler(count)...)
+}
+
+// parseVP8Frame returns whether the frame is a keyframe and its size
+func parseVP8Frame(frame []byte) (bool, image.Point, error) {
+	if len(frame) < 3 {
+		return false, image.Point{}, errors.New("VP8 frame too short")
+	}
+	if frame[0]&1 == 1 {
+		return false, image.Point{}, nil
+	}
+	if len(frame) < 10 || !bytes.Equal(frame[3:6], []byte{0x9d, 0x01, 0x2a}) {
+		return false, image.Point{}, errors.New("Invalid VP8 keyframe")
+	}
+	width := int(binary.LittleEndian.Uint16(frame[6:]) & 0x3fff)
+	height := int(binary.LittleEndian.Uint16(frame[8:]) & 0x3fff)
+	return true, image.Point{width, height}, nil
+}
+
+var annexBStartCode = []byte{0, 0, 0, 1}
+
+// NAL unit types used by the fake H.264 encoder
+const (
+	nalSlice = 1
+	nalIDR   = 5
+	nalSPS   = 7
+	nalPPS   = 8
+	nalSTAPA = 24
+	nalFUA   = 28
+)
+
+// bitWriter writes the exp-Golomb coded fields of a SPS
+type bitWriter struct {
+	data []byte
+	bits int
+}
+
+func (w *bitWriter) bit(value uint) {
+	if w.bits%8 == 0 {
+		w.data = append(w.data, 0)
+	}
+	if value != 0 {
+		w.data[len(w.data)-1] |= 0x80 >> uint(w.bits%8)
+	}
+	w.bits++
+}
+
+func (w *bitWriter) uint(value uint, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		w.bit((value >> uint(i)) & 1)
+	}
+}
+
+func (w *bitWriter) ue(value uint) {
+	value++
+	length := 0
+	for v := value; v > 1; v >>= 1 {
+		length++
+	}
+	w.uint(0, length)
+	w.uint(value, length+1)
+}
+
+// fakeSPS is a constrained baseline SPS for the size, level 3.1
+func fakeSPS(size image.Point) []byte {
+	widthMbs := (size.X + 15) / 16
+	heightMbs := (size.Y + 15) / 16
+	w := &bitWriter{}
+	w.uint(0x67, 8)
+	w.uint(66, 8)   // profile_idc
+	w.uint(0xe0, 8) // constraint_set0-2 flags
+	w.uint(31, 8)   // level_idc
+	w.ue(0)         // seq_parameter_set_id
+	w.ue(0)         // log2_max_frame_num_minus4
+	w.ue(2)         // pic_order_cnt_type
+	w.ue(1)         // max_num_ref_frames
+	w.bit(0)        // gaps_in_frame_num_value_allowed_flag
+	w.ue(uint(widthMbs - 1))
+	w.ue(uint(heightMbs - 1))
+	w.bit(1) // frame_mbs_only_flag
+	w.bit(1) // direct_8x8_inference_flag
+	cropRight, cropBottom := widthMbs*16-size.X, heightMbs*16-size.Y
+	if cropRight > 0 || cropBottom > 0 {
+		w.bit(1)
+		w.ue(0)
+		w.ue(uint(cropRight / 2))
+		w.ue(0)
+		w.ue(uint(cropBottom / 2))
+	} else {
+		w.bit(0)
+	}
+	w.bit(0) // vui_parameters_present_flag
+	w.bit(1) // rbsp_stop_one_bit
+	return w.data
+}
+
+// fakeH264Frame builds an Annex-B access unit, keyframes carry the SPS
+// and PPS before the IDR slice
+func fakeH264Frame(size image.Point, key bool, count uint32) []byte {
+	frame := []byte{}
+	if key {
+		frame = append(frame, annexBStartCode...)
+		frame = append(frame, fakeSPS(size)...)
+		frame = append(frame, annexBStartCode...)
+		frame = append(frame, 0x68, 0xce, 0x38, 0x80)
+	}
+	frame = append(frame, annexBStartCode...)
+	if key {
+		frame = append(frame, 0x60|nalIDR)
+	} else {
+		frame = append(frame, 0x40|nalSlice)
+	}
+	return append(frame, filler(count)...)
+}
+
+// splitAnnexB returns the NAL units of an access unit
+func splitAnnexB(frame []byte) [][]byte {
+	nals := [][]byte{}
+	for _, nal := range bytes.Split(frame, annexBStartCode) {
+		if len(nal) > 0 {
+			nals = append(nals, nal)
+		}
+	}
+	return nals
+}
+
+// receivedFrame is a depacketized frame
+type receivedFrame struct {
+	data []byte
+	at   time.Time
+}
+
+// loopbackClient is an in-process Pion peer that plays the browser role,
+// it negotiates thru the API and reassembles the frames it receives
+type loopbackClient struct {
+	t      *testing.T
+	server *httptest.Server
+	pc     *webrtc.PeerConnection
+	codec  string
+	id     string
+
+	frames chan receivedFrame
+	tracks chan *webrtc.Track
+
+	mutex sync.Mutex
+	// drop makes the client discard that many packets, as if lost
+	drop int
+	// losses counts the sequence gaps detected
+	losses int
+}
+
+// newLoopbackService starts the API for a service streaming a test pattern
+// of the given size, with a fake encoder for the codec
+func newLoopbackService(t *testing.T, codec encoders.VideoCodec, size image.Point) (*httptest.Server, rtc.Service) {
+	t.Helper()
+	video, err := rdisplay.NewTestPatternProvider(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := rtc.NewRemoteScreenService("", video, nil, nil, &fakeEncoderService{codec: codec}, rtc.StreamLimits{
+		MaxFPS: 30,
+		Bitrate: rtc.BitrateLimits{
+			Min: 100000,
+			Max: 4000000,
+		},
+	}, rtc.RecordingOptions{})
+	server := httptest.NewServer(MakeHandler(service, video))
+	return server, service
+}
+
+func newLoopbackClient(t *testing.T, server *httptest.Server, codec string) *loopbackClient {
+	t.Helper()
+	mediaEngine := webrtc.MediaEngine{}
+	switch codec {
+	case webrtc.VP8:
+		mediaEngine.RegisterCodec(webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000))
+	case webrtc.H264:
+		h264 := webrtc.NewRTPH264Codec(webrtc.DefaultPayloadTypeH264, 90000)
+		h264.SDPFmtpLine = "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f"
+		mediaEngine.RegisterCodec(h264)
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine))
+	pc, err := api.NewPeerConnection(webrtc.Configuration{
+		SDPSemantics: webrtc.SDPSemanticsUnifiedPlan,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &loopbackClient{
+		t:      t,
+		server: server,
+		pc:     pc,
+		codec:  codec,
+		frames: make(chan receivedFrame, 100),
+		tracks: make(chan *webrtc.Track, 1),
+	}
+	_, err = pc.AddTransceiver(webrtc.RTPCodecTypeVideo, webrtc.RtpTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionRecvonly,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc.OnTrack(func(track *webrtc.Track, receiver *webrtc.RTPReceiver) {
+		c.tracks <- track
+		go c.receive(track)
+	})
+	return c
+}
+
+// offer sends an offer thru POST /session, with the stream options
+func (c *loopbackClient) offer(options streamOptionsPayload) *http.Response {
+	c.t.Helper()
+	offer, err := c.pc.CreateOffer(nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err = c.pc.SetLocalDescription(offer); err != nil {
+		c.t.Fatal(err)
+	}
+	body, _ := json.Marshal(newSessionRequest{
+		Offer:                offer.SDP,
+		Screen:               0,
+		streamOptionsPayload: options,
+	})
+	res, err := http.Post(c.server.URL+"/session", "application/json", bytes.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return res
+}
+
+// connect starts a session and sets its answer
+func (c *loopbackClient) connect(options streamOptionsPayload) {
+	c.t.Helper()
+	c.setAnswer(c.start(options))
+}
+
+// connectThruRelay starts a session whose media goes thru a lossy relay
+func (c *loopbackClient) connectThruRelay(options streamOptionsPayload) *lossyRelay {
+	c.t.Helper()
+	answer, relay := relayAnswer(c.t, c.start(options))
+	c.setAnswer(answer)
+	return relay
+}
+
+// start creates the session and returns its answer
+func (c *loopbackClient) start(options streamOptionsPayload) string {
+	c.t.Helper()
+	res := c.offer(options)
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		c.t.Fatalf("POST /session: %s", res.Status)
+	}
+	session := newSessionResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&session); err != nil {
+		c.t.Fatal(err)
+	}
+	c.id = session.ID
+	return session.Answer
+}
+
+func (c *loopbackClient) setAnswer(answer string) {
+	c.t.Helper()
+	err := c.pc.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeAnswer,
+		SDP:  answer,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// track waits for the remote track
+func (c *loopbackClient) track(timeout time.Duration) *webrtc.Track {
+	c.t.Helper()
+	select {
+	case track := <-c.tracks:
+		return track
+	case <-time.After(timeout):
+		c.t.Fatalf("No track after %v", timeout)
+		return nil
+	}
+}
+
+// nextFrame waits for the next complete frame
+func (c *loopbackClient) nextFrame(timeout time.Duration) receivedFrame {
+	c.t.Helper()
+	select {
+	case frame := <-c.frames:
+		return frame
+	case <-time.After(timeout):
+		c.t.Fatalf("No frame after %v", timeout)
+		return receivedFrame{}
+	}
+}
+
+// dropPackets discards the next count packets
+func (c *loopbackClient) dropPackets(count int) {
+	c.mutex.Lock()
+	c.drop = count
+	c.mutex.Unlock()
+}
+
+func (c *loopbackClient) lossCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.losses
+}
+
+// receive reassembles the frames of the track, like a browser it asks for
+// a keyframe (PLI) when packets are lost and discards the frames until then.
+// Pion reads the first packet to learn the payload type, so the stream is
+// always joined mid-frame and a keyframe is requested right away
+func (c *loopbackClient) receive(track *webrtc.Track) {
+	var frame []byte
+	var lastSeq uint16
+	first, broken := true, true
+	c.pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: track.SSRC()}})
+	for {
+		packet, err := track.ReadRTP()
+		if err != nil {
+			return
+		}
+		c.mutex.Lock()
+		dropped := c.drop > 0
+		if dropped {
+			c.drop--
+		}
+		c.mutex.Unlock()
+		if dropped {
+			continue
+		}
+
+		if !first && packet.SequenceNumber != lastSeq+1 {
+			c.mutex.Lock()
+			c.losses++
+			c.mutex.Unlock()
+			broken, frame = true, nil
+			c.pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: track.SSRC()}})
+		}
+		first, lastSeq = false, packet.SequenceNumber
+
+		if frame, err = c.depacketize(frame, packet); err != nil {
+			c.t.Errorf("Depacketizing: %v", err)
+			return
+		}
+		if !packet.Marker {
+			continue
+		}
+		if broken && c.isKeyFrame(frame) {
+			broken = false
+		}
+		if !broken {
+			select {
+			case c.frames <- receivedFrame{data: frame, at: time.Now()}:
+			default:
+				c.t.Logf("Frame discarded, the test isn't reading them")
+			}
+		}
+		frame = nil
+	}
+}
+
+func (c *loopbackClient) isKeyFrame(frame []byte) bool {
+	if c.codec == webrtc.VP8 {
+		key, _, _ := parseVP8Frame(frame)
+		return key
+	}
+	for _, nal := range splitAnnexB(frame) {
+		if nal[0]&0x1f == nalIDR {
+			return true
+		}
+	}
+	return false
+}
+
+// depacketize appends the payload of the packet to the frame, H.264 frames
+// are rebuilt in Annex-B format (RFC 6184)
+func (c *loopbackClient) depacketize(frame []byte, packet *rtp.Packet) ([]byte, error) {
+	if c.codec == webrtc.VP8 {
+		vp8 := codecs.VP8Packet{}
+		payload, err := vp8.Unmarshal(packet.Payload)
+		if err != nil {
+			return nil, err
+		}
+		return append(frame, payload...), nil
+	}
+	payload := packet.Payload
+	if len(payload) < 2 {
+		return nil, errors.New("H.264 payload too short")
+	}
+	switch payload[0] & 0x1f {
+	case nalSTAPA:
+		for rest := payload[1:]; len(rest) > 2; {
+			size := int(binary.BigEndian.Uint16(rest))
+			if size+2 > len(rest) {
+				return nil, errors.New("Truncated STAP-A")
+			}
+			frame = append(frame, annexBStartCode...)
+			frame = append(frame, rest[2:2+size]...)
+			rest = rest[2+size:]
+		}
+	case nalFUA:
+		if payload[1]&0x80 != 0 {
+			frame = append(frame, annexBStartCode...)
+			frame = append(frame, payload[0]&0xe0|payload[1]&0x1f)
+		}
+		frame = append(frame, payload[2:]...)
+	default:
+		frame = append(frame, annexBStartCode...)
+		frame = append(frame, payload...)
+	}
+	return frame, nil
+}
+
+// session returns the session state reported by the API
+func (c *loopbackClient) session() sessionPayload {
+	c.t.Helper()
+	res, err := http.Get(c.server.URL + "/sessions/" + c.id)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	session := sessionPayload{}
+	if err = json.NewDecoder(res.Body).Decode(&session); err != nil {
+		c.t.Fatal(err)
+	}
+	return session
+}
+
+// close terminates the session thru the API and closes the peer connection
+func (c *loopbackClient) close() {
+	if c.id != "" {
+		req, _ := http.NewRequest(http.MethodDelete, c.server.URL+"/sessions/"+c.id, nil)
+		if res, err := http.DefaultClient.Do(req); err == nil {
+			res.Body.Close()
+		}
+	}
+	c.pc.Close()
+}
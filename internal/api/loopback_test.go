@@ -0,0 +1,252 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v2"
+	"github.com/rviscarra/webrtc-remote-screen/internal/encoders"
+)
+
+const (
+	connectTimeout = 10 * time.Second
+	frameTimeout   = 2 * time.Second
+)
+
+// The test pattern is scaled down to maxWidth keeping its aspect ratio
+var (
+	loopbackScreen = image.Point{640, 360}
+	loopbackVideo  = image.Point{320, 180}
+)
+
+// checkCadence receives frames for the duration and checks they arrive at
+// the rate they're encoded, which can't be above fps. A slow machine may
+// encode fewer frames, the capture drops them by design in that case
+func checkCadence(t *testing.T, client *loopbackClient, fps int, duration time.Duration) {
+	t.Helper()
+	encoded := client.session().Frames.Encoded
+	start := time.Now()
+	received := 0
+	for time.Since(start) < duration {
+		client.nextFrame(frameTimeout)
+		received++
+	}
+	elapsed := time.Since(start)
+	encoded = client.session().Frames.Encoded - encoded
+	if rate := float64(received) / elapsed.Seconds(); rate > float64(fps)*1.3 {
+		t.Errorf("Received %.1f fps, expected %d at most", rate, fps)
+	}
+	// A frame may be in flight at either end of the period
+	if diff := int(encoded) - received; diff < -2 || diff > 2 {
+		t.Errorf("Received %d frames, %d were encoded", received, encoded)
+	}
+	if received == 0 {
+		t.Error("No frames received")
+	}
+}
+
+func TestLoopbackVP8(t *testing.T) {
+	server, _ := newLoopbackService(t, encoders.VP8Codec, loopbackScreen)
+	defer server.Close()
+	client := newLoopbackClient(t, server, webrtc.VP8)
+	defer client.close()
+	client.connect(streamOptionsPayload{FPS: 10, MaxWidth: loopbackVideo.X})
+
+	if codec := client.track(connectTimeout).Codec().Name; codec != webrtc.VP8 {
+		t.Fatalf("Got a %s track, expected VP8", codec)
+	}
+	key, size, err := parseVP8Frame(client.nextFrame(connectTimeout).data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key {
+		t.Fatal("The first frame isn't a keyframe")
+	}
+	if size != loopbackVideo {
+		t.Errorf("Got a %v video, expected %v", size, loopbackVideo)
+	}
+	checkCadence(t, client, 10, 2*time.Second)
+
+	session := client.session()
+	if session.Codec != webrtc.VP8 || session.State != "connected" {
+		t.Errorf("Unexpected session state %+v", session)
+	}
+	if session.Frames.Encoded == 0 {
+		t.Errorf("The session reports no encoded frames")
+	}
+}
+
+func TestLoopbackH264(t *testing.T) {
+	server, _ := newLoopbackService(t, encoders.H264Codec, loopbackScreen)
+	defer server.Close()
+	client := newLoopbackClient(t, server, webrtc.H264)
+	defer client.close()
+	client.connect(streamOptionsPayload{FPS: 10, MaxWidth: loopbackVideo.X})
+
+	if codec := client.track(connectTimeout).Codec().Name; codec != webrtc.H264 {
+		t.Fatalf("Got a %s track, expected H264", codec)
+	}
+	nals := splitAnnexB(client.nextFrame(connectTimeout).data)
+	if len(nals) != 3 || nals[2][0]&0x1f != nalIDR {
+		t.Fatalf("The first frame isn't SPS, PPS and IDR (%d NAL units)", len(nals))
+	}
+	if !bytes.Equal(nals[0], fakeSPS(loopbackVideo)) {
+		t.Errorf("The SPS doesn't match a %v video", loopbackVideo)
+	}
+	// The slices are fragmented (FU-A), check they're rebuilt whole
+	if len(nals[2]) != 1+fakeFrameSize {
+		t.Errorf("Got a %d bytes IDR slice, expected %d", len(nals[2]), 1+fakeFrameSize)
+	}
+	checkCadence(t, client, 10, 2*time.Second)
+}
+
+// TestLoopbackNetworkLoss drops RTP packets on the UDP path between the
+// agent and the client. The client must detect it, ask for a keyframe and
+// get one. pion v2.1.0 has no virtual network for peer connections, the
+// loss comes from a relay in front of the agent ICE candidate
+func TestLoopbackNetworkLoss(t *testing.T) {
+	server, _ := newLoopbackService(t, encoders.VP8Codec, loopbackScreen)
+	defer server.Close()
+	client := newLoopbackClient(t, server, webrtc.VP8)
+	defer client.close()
+	relay := client.connectThruRelay(streamOptionsPayload{FPS: 15, MaxWidth: loopbackVideo.X})
+	defer relay.close()
+	client.track(connectTimeout)
+
+	client.nextFrame(connectTimeout)
+	// The keyframe requests closer than 500 ms to the previous one are ignored
+	time.Sleep(600 * time.Millisecond)
+	relay.dropPackets(2)
+
+	checkKeyFrameAfterLoss(t, client)
+	if dropped := relay.droppedCount(); dropped != 2 {
+		t.Errorf("The relay dropped %d packets, expected 2", dropped)
+	}
+}
+
+// TestLoopbackPacketLoss drops packets on the receiver side, after the
+// transport, with the same outcome expected
+func TestLoopbackPacketLoss(t *testing.T) {
+	server, _ := newLoopbackService(t, encoders.VP8Codec, loopbackScreen)
+	defer server.Close()
+	client := newLoopbackClient(t, server, webrtc.VP8)
+	defer client.close()
+	client.connect(streamOptionsPayload{FPS: 15, MaxWidth: loopbackVideo.X})
+	client.track(connectTimeout)
+
+	client.nextFrame(connectTimeout)
+	time.Sleep(600 * time.Millisecond)
+	client.dropPackets(2)
+
+	checkKeyFrameAfterLoss(t, client)
+}
+
+// checkKeyFrameAfterLoss waits for the client to see the loss, the first
+// frame after it must be a keyframe
+func checkKeyFrameAfterLoss(t *testing.T, client *loopbackClient) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		frame := client.nextFrame(frameTimeout)
+		if client.lossCount() == 0 {
+			continue
+		}
+		key, _, err := parseVP8Frame(frame.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !key {
+			t.Fatal("The first frame after the loss isn't a keyframe")
+		}
+		return
+	}
+	t.Fatal("No keyframe after the packet loss")
+}
+
+func TestLoopbackCodecMismatch(t *testing.T) {
+	server, _ := newLoopbackService(t, encoders.H264Codec, loopbackScreen)
+	defer server.Close()
+	client := newLoopbackClient(t, server, webrtc.VP8)
+	defer client.close()
+
+	res := client.offer(streamOptionsPayload{})
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Got %s, expected 400", res.Status)
+	}
+	payload := codecErrorPayload{}
+	if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload.Offered) != 1 || payload.Offered[0].Codec != webrtc.VP8 || payload.Offered[0].Rejected == "" {
+		t.Errorf("Unexpected offered codecs %+v", payload.Offered)
+	}
+	if len(payload.Supported) != 1 || payload.Supported[0] != webrtc.H264 {
+		t.Errorf("Unexpected supported codecs %v", payload.Supported)
+	}
+}
+
+func TestMalformedRequests(t *testing.T) {
+	server, _ := newLoopbackService(t, encoders.VP8Codec, loopbackScreen)
+	defer server.Close()
+	client := newLoopbackClient(t, server, webrtc.VP8)
+	defer client.close()
+	client.connect(streamOptionsPayload{})
+
+	requests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/session"},
+		{http.MethodPatch, "/session/" + client.id},
+	}
+	for _, r := range requests {
+		req, err := http.NewRequest(r.method, server.URL+r.path, bytes.NewBufferString("{"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s %s got %s, expected 400", r.method, r.path, res.Status)
+		}
+	}
+}
+
+func TestSnapshotRegion(t *testing.T) {
+	server, _ := newLoopbackService(t, encoders.VP8Codec, loopbackScreen)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/screens/0/snapshot?x=100&y=40&width=120&height=90")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Got %s, expected 200", res.Status)
+	}
+	img, err := png.Decode(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size != (image.Point{120, 90}) {
+		t.Errorf("Got a %v snapshot, expected 120x90", size)
+	}
+
+	res, err = http.Get(server.URL + "/screens/0/snapshot?x=600&width=100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("A region out of the screen got %s, expected 400", res.Status)
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+
+	"github.com/rviscarra/webrtc-remote-screen/internal/rtc"
+)
+
+// Default JPEG quality of the MJPEG frames, lower than the snapshots
+// since they're sent continuously
+const mjpegDefaultQuality = 60
+
+// handleMJPEG streams a screen as multipart/x-mixed-replace JPEG frames,
+// for clients without WebRTC. fps and quality are optional query params
+func handleMJPEG(w http.ResponseWriter, r *http.Request, webrtc rtc.Service, screenIx int) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	fps, err := queryInt(r, "fps")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	quality, err := queryInt(r, "quality")
+	if err != nil || quality > 100 {
+		http.Error(w, "Invalid quality", http.StatusBadRequest)
+		return
+	}
+	if quality == 0 {
+		quality = mjpegDefaultQuality
+	}
+
+	// The stream stops when the client goes away or the handler gives up
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	frames, err := webrtc.ScreenFrames(screenIx, fps, ctx.Done())
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mw.Boundary())
+	w.Header().Set("Cache-Control", "no-store")
+	buffer := bytes.Buffer{}
+	for frame := range frames {
+		buffer.Reset()
+		if err := jpeg.Encode(&buffer, frame, &jpeg.Options{Quality: quality}); err != nil {
+			fmt.Printf("Error: %v", err)
+			break
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":   []string{mimeJPEG},
+			"Content-Length": []string{strconv.Itoa(buffer.Len())},
+		})
+		if err != nil {
+			break
+		}
+		if _, err := part.Write(buffer.Bytes()); err != nil {
+			break
+		}
+		flusher.Flush()
+	}
+}
@@ -1,18 +1,137 @@
 package api
 
+import (
+	"time"
+
+	"github.com/rviscarra/webrtc-remote-screen/internal/rtc"
+)
+
+// streamOptionsPayload carries the video settings requested by the client,
+// bitrate is expressed in kbps
+type streamOptionsPayload struct {
+	FPS       int `json:"fps,omitempty"`
+	MaxWidth  int `json:"maxWidth,omitempty"`
+	MaxHeight int `json:"maxHeight,omitempty"`
+	Bitrate   int `json:"bitrate,omitempty"`
+	// Cursor is "none", "composite" or "channel"
+	Cursor string `json:"cursor,omitempty"`
+	// Chroma444 asks for 4:4:4 video, only with VP9
+	Chroma444 bool `json:"chroma444,omitempty"`
+	// Codecs is the client codec preference, e.g. ["vp8", "h264"]
+	Codecs []string `json:"codecs,omitempty"`
+}
+
+func (p streamOptionsPayload) options() rtc.StreamOptions {
+	return rtc.StreamOptions{
+		FPS:       p.FPS,
+		MaxWidth:  p.MaxWidth,
+		MaxHeight: p.MaxHeight,
+		Bitrate:   p.Bitrate * 1000,
+		Cursor:    rtc.CursorMode(p.Cursor),
+		Chroma444: p.Chroma444,
+		Codecs:    p.Codecs,
+	}
+}
+
 type newSessionRequest struct {
-	Offer  string `json:"offer"`
-	Screen int    `json:"screen"`
+	Offer   string `json:"offer"`
+	Screen  int    `json:"screen"`
+	Trickle bool   `json:"trickle"`
+	streamOptionsPayload
 }
 
 type newSessionResponse struct {
+	ID     string `json:"id"`
 	Answer string `json:"answer"`
 }
 
+// codecErrorPayload explains why an offer was rejected
+type codecErrorPayload struct {
+	Error     string                `json:"error"`
+	Offered   []offeredCodecPayload `json:"offered"`
+	Supported []string              `json:"supported"`
+}
+
+type offeredCodecPayload struct {
+	Codec       string `json:"codec"`
+	PayloadType uint8  `json:"payloadType"`
+	Fmtp        string `json:"fmtp,omitempty"`
+	// Rejected is empty for the usable formats
+	Rejected string `json:"rejected,omitempty"`
+}
+
 type screenPayload struct {
-	Index int `json:"index"`
+	Index       int     `json:"index"`
+	X           int     `json:"x"`
+	Y           int     `json:"y"`
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+	Primary     bool    `json:"primary"`
+	Name        string  `json:"name,omitempty"`
+	RefreshRate float64 `json:"refreshRate,omitempty"`
+	Scale       float64 `json:"scale"`
 }
 
 type screensResponse struct {
 	Screens []screenPayload `json:"screens"`
 }
+
+type sessionPayload struct {
+	ID         string        `json:"id"`
+	State      string        `json:"state"`
+	Codec      string        `json:"codec"`
+	Screen     int           `json:"screen"`
+	StartedAt  time.Time     `json:"startedAt"`
+	RemoteAddr string        `json:"remoteAddr"`
+	Recording  string        `json:"recording,omitempty"`
+	Frames     framesPayload `json:"frames"`
+}
+
+// framesPayload counts the frames of the session video, latency is in ms
+type framesPayload struct {
+	Captured  uint64  `json:"captured"`
+	Dropped   uint64  `json:"dropped"`
+	Unchanged uint64  `json:"unchanged"`
+	Encoded   uint64  `json:"encoded"`
+	Latency   float64 `json:"latency"`
+}
+
+type recordingResponse struct {
+	Path string `json:"path"`
+}
+
+type sessionsResponse struct {
+	Sessions []sessionPayload `json:"sessions"`
+}
+
+type switchScreenRequest struct {
+	Screen int `json:"screen"`
+}
+
+type candidatePayload struct {
+	Candidate     string `json:"candidate"`
+	SDPMid        string `json:"sdpMid"`
+	SDPMLineIndex uint16 `json:"sdpMLineIndex"`
+}
+
+type trickleRequest struct {
+	Candidates []candidatePayload `json:"candidates"`
+}
+
+type trickleResponse struct {
+	Candidates []candidatePayload `json:"candidates"`
+	Done       bool               `json:"done"`
+}
+
+// signalingMessage is exchanged thru the WebSocket signaling channel, the
+// client sends offer, answer, candidate and renegotiate messages, the server
+// sends session, answer, offer, candidate, end-of-candidates and error ones
+type signalingMessage struct {
+	Type      string            `json:"type"`
+	ID        string            `json:"id,omitempty"`
+	SDP       string            `json:"sdp,omitempty"`
+	Screen    int               `json:"screen,omitempty"`
+	Candidate *candidatePayload `json:"candidate,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	streamOptionsPayload
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+// lossyRelay is a UDP relay that stands between the client and the agent
+// ICE candidate, like a lossy network hop it can drop the RTP packets
+// sent to the client. ICE, DTLS and RTCP always go thru
+type lossyRelay struct {
+	t        *testing.T
+	conn     *net.UDPConn
+	upstream *net.UDPAddr
+
+	mutex sync.Mutex
+	// peers are the sockets facing the agent, one per client address
+	peers map[string]*net.UDPConn
+	// drop is the number of RTP packets still to drop
+	drop    int
+	dropped int
+	closed  bool
+}
+
+// newLossyRelay starts relaying to the agent address, it listens on the
+// same interface
+func newLossyRelay(t *testing.T, upstream *net.UDPAddr) *lossyRelay {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: upstream.IP})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &lossyRelay{
+		t:        t,
+		conn:     conn,
+		upstream: upstream,
+		peers:    make(map[string]*net.UDPConn),
+	}
+	go r.forward()
+	return r
+}
+
+// addr is where the client has to send its packets
+func (r *lossyRelay) addr() *net.UDPAddr {
+	return r.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// forward relays the client packets to the agent
+func (r *lossyRelay) forward() {
+	buffer := make([]byte, 1500)
+	for {
+		n, client, err := r.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		peer, err := r.peer(client)
+		if err != nil {
+			r.t.Logf("Relay: %v", err)
+			return
+		}
+		peer.Write(buffer[:n])
+	}
+}
+
+// peer returns the socket relaying the packets of a client address
+func (r *lossyRelay) peer(client *net.UDPAddr) (*net.UDPConn, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if peer, found := r.peers[client.String()]; found {
+		return peer, nil
+	}
+	if r.closed {
+		return nil, fmt.Errorf("closed")
+	}
+	peer, err := net.DialUDP("udp", &net.UDPAddr{IP: r.upstream.IP}, r.upstream)
+	if err != nil {
+		return nil, err
+	}
+	r.peers[client.String()] = peer
+	go r.backward(peer, client)
+	return peer, nil
+}
+
+// backward relays the agent packets to the client, dropping RTP as told
+func (r *lossyRelay) backward(peer *net.UDPConn, client *net.UDPAddr) {
+	buffer := make([]byte, 1500)
+	for {
+		n, err := peer.Read(buffer)
+		if err != nil {
+			return
+		}
+		if isRTP(buffer[:n]) && r.shouldDrop() {
+			continue
+		}
+		r.conn.WriteToUDP(buffer[:n], client)
+	}
+}
+
+// isRTP tells RTP apart from STUN, DTLS and RTCP (RFC 7983 and RFC 5761),
+// SRTP leaves the RTP header in the clear
+func isRTP(packet []byte) bool {
+	if len(packet) < 12 || packet[0] < 128 || packet[0] > 191 {
+		return false
+	}
+	payloadType := packet[1] & 0x7f
+	return payloadType < 64 || payloadType > 95
+}
+
+func (r *lossyRelay) shouldDrop() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.drop == 0 {
+		return false
+	}
+	r.drop--
+	r.dropped++
+	return true
+}
+
+// dropPackets drops the next count RTP packets sent to the client
+func (r *lossyRelay) dropPackets(count int) {
+	r.mutex.Lock()
+	r.drop = count
+	r.mutex.Unlock()
+}
+
+func (r *lossyRelay) droppedCount() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.dropped
+}
+
+func (r *lossyRelay) close() {
+	r.mutex.Lock()
+	r.closed = true
+	for _, peer := range r.peers {
+		peer.Close()
+	}
+	r.mutex.Unlock()
+	r.conn.Close()
+}
+
+// Host candidates of an SDP: the address and port before "typ host"
+var hostCandidate = regexp.MustCompile(`(a=candidate:\S+ \d+ udp \d+ )(\S+) (\d+)( typ host)`)
+
+// relayAnswer starts a relay in front of the first host candidate of the
+// answer and points every candidate of the answer to it
+func relayAnswer(t *testing.T, answer string) (string, *lossyRelay) {
+	t.Helper()
+	match := hostCandidate.FindStringSubmatch(answer)
+	if match == nil {
+		t.Fatal("The answer has no UDP host candidate")
+	}
+	upstream, err := net.ResolveUDPAddr("udp", net.JoinHostPort(match[2], match[3]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay := newLossyRelay(t, upstream)
+	relayed := relay.addr()
+	answer = hostCandidate.ReplaceAllString(answer, fmt.Sprintf("${1}%s %d${4}", relayed.IP, relayed.Port))
+	return answer, relay
+}
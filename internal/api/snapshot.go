@@ -0,0 +1,194 @@
+package api
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nfnt/resize"
+	"github.com/rviscarra/webrtc-remote-screen/internal/rdisplay"
+)
+
+// How long a snapshot waits for the grabber to deliver a frame
+const snapshotTimeout = 5 * time.Second
+
+// Image formats a snapshot can be encoded to, there's no WebP encoder
+// so WebP requests are answered with 406 Not Acceptable
+const (
+	mimePNG  = "image/png"
+	mimeJPEG = "image/jpeg"
+)
+
+// snapshotFormat picks the image format from the format query param or,
+// without it, from the Accept header. An empty result means none is acceptable
+func snapshotFormat(r *http.Request) string {
+	switch r.URL.Query().Get("format") {
+	case "png":
+		return mimePNG
+	case "jpeg", "jpg":
+		return mimeJPEG
+	case "":
+	default:
+		return ""
+	}
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return mimePNG
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		switch mediaType {
+		case mimePNG, "image/*", "*/*":
+			return mimePNG
+		case mimeJPEG:
+			return mimeJPEG
+		}
+	}
+	return ""
+}
+
+// queryInt parses an optional non-negative integer query param
+func queryInt(r *http.Request, name string) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid %s", name)
+	}
+	return n, nil
+}
+
+// snapshotRegion returns the area to capture, x/y/width/height are relative
+// to the screen and default to the whole screen
+func snapshotRegion(r *http.Request, bounds image.Rectangle) (image.Rectangle, error) {
+	var values [4]int
+	for i, name := range []string{"x", "y", "width", "height"} {
+		n, err := queryInt(r, name)
+		if err != nil {
+			return image.Rectangle{}, err
+		}
+		values[i] = n
+	}
+	x, y, width, height := values[0], values[1], values[2], values[3]
+	if width == 0 {
+		width = bounds.Dx() - x
+	}
+	if height == 0 {
+		height = bounds.Dy() - y
+	}
+	region := image.Rect(x, y, x+width, y+height).Add(bounds.Min)
+	if region.Empty() || !region.In(bounds) {
+		return image.Rectangle{}, fmt.Errorf("Region out of the screen bounds")
+	}
+	return region, nil
+}
+
+// captureFrame grabs a single frame of the screen
+func captureFrame(display rdisplay.Service, screen rdisplay.Screen) (*image.RGBA, error) {
+	grabber, err := display.CreateScreenGrabber(screen, 1)
+	if err != nil {
+		return nil, err
+	}
+	grabber.Start()
+	defer grabber.Stop()
+
+	timer := time.NewTimer(snapshotTimeout)
+	defer timer.Stop()
+	select {
+	case frame, ok := <-grabber.Frames():
+		if !ok {
+			return nil, fmt.Errorf("Can't capture screen %d", screen.Index)
+		}
+		return frame, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("Timed out capturing screen %d", screen.Index)
+	}
+}
+
+// handleSnapshot serves a still image of a screen, optionally cropped
+// (x, y, width, height) and scaled down to fit maxWidth/maxHeight
+func handleSnapshot(w http.ResponseWriter, r *http.Request, display rdisplay.Service, screenIx int) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	format := snapshotFormat(r)
+	if format == "" {
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+
+	screens, err := display.Screens()
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	if screenIx < 0 || screenIx >= len(screens) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	screen := screens[screenIx]
+
+	region, err := snapshotRegion(r, screen.Bounds)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxWidth, err := queryInt(r, "maxWidth")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxHeight, err := queryInt(r, "maxHeight")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	quality, err := queryInt(r, "quality")
+	if err != nil || quality > 100 {
+		http.Error(w, "Invalid quality", http.StatusBadRequest)
+		return
+	}
+	if quality == 0 {
+		quality = jpeg.DefaultQuality
+	}
+
+	// Capture the region only, the grabber works on any rectangle
+	screen.Bounds = region
+	frame, err := captureFrame(display, screen)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	var img image.Image = frame
+	if maxWidth > 0 || maxHeight > 0 {
+		img = resize.Thumbnail(uint(orMax(maxWidth)), uint(orMax(maxHeight)), frame, resize.Lanczos3)
+	}
+
+	w.Header().Set("Content-Type", format)
+	w.Header().Set("Cache-Control", "no-store")
+	if format == mimeJPEG {
+		err = jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	} else {
+		err = png.Encode(w, img)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v", err)
+	}
+}
+
+// orMax turns an unset dimension into an unbounded one
+func orMax(n int) int {
+	if n == 0 {
+		return math.MaxInt32
+	}
+	return n
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/rviscarra/webrtc-remote-screen/internal/auth"
+	"github.com/rviscarra/webrtc-remote-screen/internal/rtc"
+	"golang.org/x/net/websocket"
+)
+
+// signalingSession is the state of a WebSocket signaling connection, it
+// owns at most one remote screen session
+type signalingSession struct {
+	ws         *websocket.Conn
+	webrtc     rtc.Service
+	remoteAddr string
+	// viewOnly is set for the share link holders, see auth.ScopeView
+	viewOnly  bool
+	sendMutex sync.Mutex
+	peer      rtc.RemoteScreenConnection
+}
+
+func (s *signalingSession) send(msg signalingMessage) error {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	return websocket.JSON.Send(s.ws, msg)
+}
+
+func (s *signalingSession) sendError(err error) {
+	s.send(signalingMessage{
+		Type:  "error",
+		Error: err.Error(),
+	})
+}
+
+// SendOffer implements rtc.Signaler
+func (s *signalingSession) SendOffer(offer string) error {
+	return s.send(signalingMessage{
+		Type: "offer",
+		SDP:  offer,
+	})
+}
+
+// forwardCandidates sends the server candidates as they are gathered
+func (s *signalingSession) forwardCandidates(peer rtc.RemoteScreenConnection) {
+	for {
+		candidates, done, err := peer.LocalCandidates(trickleWait)
+		if err != nil {
+			return
+		}
+		for _, c := range candidates {
+			err := s.send(signalingMessage{
+				Type: "candidate",
+				Candidate: &candidatePayload{
+					Candidate:     c.Candidate,
+					SDPMid:        c.SDPMid,
+					SDPMLineIndex: c.SDPMLineIndex,
+				},
+			})
+			if err != nil {
+				return
+			}
+		}
+		if done {
+			s.send(signalingMessage{Type: "end-of-candidates"})
+			return
+		}
+		select {
+		case <-peer.Done():
+			return
+		default:
+		}
+	}
+}
+
+func (s *signalingSession) handle(msg *signalingMessage) error {
+	if msg.Type == "offer" {
+		if s.peer != nil {
+			return fmt.Errorf("The signaling channel already has a session")
+		}
+		return s.startSession(msg)
+	}
+	if s.peer == nil {
+		return fmt.Errorf("The signaling channel has no session")
+	}
+	switch msg.Type {
+	case "answer":
+		return s.peer.AcceptAnswer(msg.SDP)
+	case "candidate":
+		if msg.Candidate == nil {
+			return nil
+		}
+		return s.peer.AddICECandidate(rtc.ICECandidate{
+			Candidate:     msg.Candidate.Candidate,
+			SDPMid:        msg.Candidate.SDPMid,
+			SDPMLineIndex: msg.Candidate.SDPMLineIndex,
+		})
+	case "renegotiate":
+		return s.peer.Renegotiate()
+	}
+	return fmt.Errorf("Unknown signaling message type %q", msg.Type)
+}
+
+func (s *signalingSession) startSession(msg *signalingMessage) error {
+	options := msg.options()
+	options.ViewOnly = s.viewOnly
+	peer, err := s.webrtc.CreateRemoteScreenConnection(msg.Screen, options, s.remoteAddr)
+	if err != nil {
+		return err
+	}
+	peer.SetSignaler(s)
+	answer, err := peer.ProcessOffer(msg.SDP, true)
+	if err != nil {
+		peer.Close()
+		return err
+	}
+	s.peer = peer
+
+	err = s.send(signalingMessage{
+		Type: "answer",
+		ID:   peer.ID(),
+		SDP:  answer,
+	})
+	if err != nil {
+		return err
+	}
+	go s.forwardCandidates(peer)
+	// Ending the session, from the API or due to a connection failure,
+	// also ends the signaling channel
+	go func() {
+		<-peer.Done()
+		s.ws.Close()
+	}()
+	return nil
+}
+
+// checkOrigin rejects cross-origin WebSocket handshakes, browsers don't
+// apply the same-origin policy to them
+func checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin == nil {
+		return nil
+	}
+	if origin.Host != r.Host {
+		return fmt.Errorf("Cross-origin signaling request from %s", origin)
+	}
+	config.Origin = origin
+	return nil
+}
+
+// makeSignalingHandler returns the WebSocket signaling handler, closing
+// the WebSocket closes its session
+func makeSignalingHandler(webrtc rtc.Service) http.Handler {
+	return websocket.Server{
+		Handshake: checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			s := &signalingSession{
+				ws:         ws,
+				webrtc:     webrtc,
+				remoteAddr: ws.Request().RemoteAddr,
+				viewOnly:   auth.RequestScope(ws.Request()) == auth.ScopeView,
+			}
+			defer func() {
+				if s.peer != nil {
+					s.peer.Close()
+				}
+			}()
+			for {
+				msg := signalingMessage{}
+				if err := websocket.JSON.Receive(ws, &msg); err != nil {
+					return
+				}
+				if err := s.handle(&msg); err != nil {
+					log.Printf("Signaling error: %v", err)
+					s.sendError(err)
+				}
+			}
+		},
+	}
+}
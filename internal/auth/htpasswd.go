@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HtpasswdAuthenticator implements HTTP basic auth against an htpasswd file,
+// only the bcrypt and SHA1 ({SHA}) hashes are supported
+type HtpasswdAuthenticator struct {
+	realm  string
+	hashes map[string]string
+}
+
+// NewHtpasswdAuthenticator loads the users from an htpasswd file
+func NewHtpasswdAuthenticator(path string, realm string) (*HtpasswdAuthenticator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: malformed entry", path, lineNumber)
+		}
+		user, hash := parts[0], parts[1]
+		if !isBcrypt(hash) && !strings.HasPrefix(hash, "{SHA}") {
+			return nil, fmt.Errorf("%s:%d: unsupported hash for user %s, use bcrypt (htpasswd -B)", path, lineNumber, user)
+		}
+		hashes[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &HtpasswdAuthenticator{
+		realm:  realm,
+		hashes: hashes,
+	}, nil
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$")
+}
+
+func checkPassword(hash, password string) bool {
+	if isBcrypt(hash) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	sum := sha1.Sum([]byte(password))
+	expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+}
+
+// Authenticate checks the basic auth credentials
+func (a *HtpasswdAuthenticator) Authenticate(r *http.Request) error {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return ErrNoCredentials
+	}
+	hash, found := a.hashes[user]
+	if !found || !checkPassword(hash, password) {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// Challenge asks the browser for basic auth credentials
+func (a *HtpasswdAuthenticator) Challenge() string {
+	return fmt.Sprintf("Basic realm=%q", a.realm)
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeHtpasswd(t *testing.T, lines ...string) string {
+	t.Helper()
+	file, err := ioutil.TempFile("", "htpasswd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(strings.Join(lines, "\n")); err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
+
+func TestHtpasswdAuthenticate(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeHtpasswd(t,
+		"# comment",
+		"",
+		"alice:"+string(bcryptHash),
+		// htpasswd -s bob password
+		"bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=",
+	)
+	defer os.Remove(path)
+	authenticator, err := NewHtpasswdAuthenticator(path, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user     string
+		password string
+		expected error
+	}{
+		{"alice", "secret", nil},
+		{"alice", "wrong", ErrInvalidCredentials},
+		{"bob", "password", nil},
+		{"bob", "secret", ErrInvalidCredentials},
+		{"carol", "secret", ErrInvalidCredentials},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.SetBasicAuth(test.user, test.password)
+		if err := authenticator.Authenticate(r); err != test.expected {
+			t.Errorf("%s:%s got %v, expected %v", test.user, test.password, err, test.expected)
+		}
+	}
+	if err := authenticator.Authenticate(httptest.NewRequest("GET", "/", nil)); err != ErrNoCredentials {
+		t.Errorf("A request without credentials got %v", err)
+	}
+	if challenge := authenticator.Challenge(); challenge != `Basic realm="test"` {
+		t.Errorf("Unexpected challenge %s", challenge)
+	}
+}
+
+func TestHtpasswdInvalidFile(t *testing.T) {
+	tests := []struct {
+		line  string
+		error string
+	}{
+		{"alice", "malformed entry"},
+		{"alice:$apr1$salt$hash", "unsupported hash"},
+		{"alice:plaintext", "unsupported hash"},
+	}
+	for _, test := range tests {
+		path := writeHtpasswd(t, "# users", test.line)
+		_, err := NewHtpasswdAuthenticator(path, "test")
+		os.Remove(path)
+		if err == nil || !strings.Contains(err.Error(), ":2: "+test.error) {
+			t.Errorf("%q got %v, expected a line 2 %s error", test.line, err, test.error)
+		}
+	}
+}
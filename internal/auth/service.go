@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrNoCredentials is returned when the request doesn't carry the
+// credentials an authenticator is looking for
+var ErrNoCredentials = errors.New("No credentials")
+
+// ErrInvalidCredentials is returned when the credentials are wrong or expired
+var ErrInvalidCredentials = errors.New("Invalid credentials")
+
+// Authenticator validates the credentials of a request
+type Authenticator interface {
+	Authenticate(r *http.Request) error
+}
+
+// Challenger is implemented by the authenticators that need to send
+// a WWW-Authenticate header when the request is rejected
+type Challenger interface {
+	Challenge() string
+}
+
+// Scope is what an authenticated request is allowed to do
+type Scope int
+
+const (
+	// ScopeFull grants access to the whole API
+	ScopeFull Scope = iota
+	// ScopeView only allows watching the screens, without remote input
+	// nor managing the sessions
+	ScopeView
+)
+
+// Scoper is implemented by the authenticators whose credentials grant
+// less than ScopeFull
+type Scoper interface {
+	Scope() Scope
+}
+
+type scopeKey struct{}
+
+// RequestScope returns the scope granted to a request by the middleware,
+// ScopeFull if it wasn't restricted
+func RequestScope(r *http.Request) Scope {
+	if scope, ok := r.Context().Value(scopeKey{}).(Scope); ok {
+		return scope
+	}
+	return ScopeFull
+}
+
+// Middleware only lets thru the requests accepted by any of the authenticators,
+// with no authenticators every request is accepted. The scope of the first
+// authenticator that accepts the request is available thru RequestScope
+func Middleware(next http.Handler, authenticators ...Authenticator) http.Handler {
+	if len(authenticators) == 0 {
+		return next
+	}
+	challenges := make([]string, 0, len(authenticators))
+	for _, authenticator := range authenticators {
+		if challenger, ok := authenticator.(Challenger); ok {
+			challenges = append(challenges, challenger.Challenge())
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, authenticator := range authenticators {
+			if authenticator.Authenticate(r) == nil {
+				if scoper, ok := authenticator.(Scoper); ok {
+					r = r.WithContext(context.WithValue(r.Context(), scopeKey{}, scoper.Scope()))
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if len(challenges) > 0 {
+			w.Header().Set("WWW-Authenticate", strings.Join(challenges, ", "))
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+}
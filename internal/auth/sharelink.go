@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Query params carried by the share links
+const (
+	shareExpiresParam   = "expires"
+	shareSignatureParam = "signature"
+)
+
+// ShareLinkAuthenticator accepts requests carrying a HMAC-SHA256 signed
+// expiration time, so access can be handed out without sharing credentials
+type ShareLinkAuthenticator struct {
+	secret []byte
+	maxTTL time.Duration
+}
+
+// NewShareLinkAuthenticator creates a ShareLinkAuthenticator, links can't
+// be valid for longer than maxTTL
+func NewShareLinkAuthenticator(secret string, maxTTL time.Duration) *ShareLinkAuthenticator {
+	return &ShareLinkAuthenticator{
+		secret: []byte(secret),
+		maxTTL: maxTTL,
+	}
+}
+
+func (a *ShareLinkAuthenticator) sign(expires string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns the query params of a link valid until expiresAt
+func (a *ShareLinkAuthenticator) Sign(expiresAt time.Time) url.Values {
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	return url.Values{
+		shareExpiresParam:   []string{expires},
+		shareSignatureParam: []string{a.sign(expires)},
+	}
+}
+
+// Authenticate checks the link signature and expiration time
+func (a *ShareLinkAuthenticator) Authenticate(r *http.Request) error {
+	query := r.URL.Query()
+	expires := query.Get(shareExpiresParam)
+	signature := query.Get(shareSignatureParam)
+	if expires == "" || signature == "" {
+		return ErrNoCredentials
+	}
+	if !hmac.Equal([]byte(signature), []byte(a.sign(expires))) {
+		return ErrInvalidCredentials
+	}
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// Scope implements Scoper, share links only let their holders watch
+func (a *ShareLinkAuthenticator) Scope() Scope {
+	return ScopeView
+}
+
+type shareLinkRequest struct {
+	TTL int `json:"ttl"`
+}
+
+type shareLinkResponse struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// ShareLinkHandler returns an HTTP handler that issues share links, it must
+// be protected by the authentication middleware
+func ShareLinkHandler(a *ShareLinkAuthenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		req := shareLinkRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ttl := time.Duration(req.TTL) * time.Second
+		if ttl <= 0 || ttl > a.maxTTL {
+			ttl = a.maxTTL
+		}
+		expiresAt := time.Now().Add(ttl)
+
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		link := url.URL{
+			Scheme:   scheme,
+			Host:     r.Host,
+			Path:     "/",
+			RawQuery: a.Sign(expiresAt).Encode(),
+		}
+		payload, err := json.Marshal(shareLinkResponse{
+			URL:     link.String(),
+			Expires: expiresAt,
+		})
+		if err != nil {
+			fmt.Printf("Error: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(payload)
+	})
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func shareLinkRequestFor(query url.Values) *http.Request {
+	return httptest.NewRequest("GET", "/?"+query.Encode(), nil)
+}
+
+func TestShareLinkAuthenticate(t *testing.T) {
+	authenticator := NewShareLinkAuthenticator("secret", time.Hour)
+	other := NewShareLinkAuthenticator("other", time.Hour)
+
+	tampered := authenticator.Sign(time.Now().Add(time.Minute))
+	tampered.Set(shareExpiresParam, strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+
+	tests := []struct {
+		name     string
+		query    url.Values
+		expected error
+	}{
+		{"valid", authenticator.Sign(time.Now().Add(time.Minute)), nil},
+		{"expired", authenticator.Sign(time.Now().Add(-time.Minute)), ErrInvalidCredentials},
+		{"other secret", other.Sign(time.Now().Add(time.Minute)), ErrInvalidCredentials},
+		{"tampered expiration", tampered, ErrInvalidCredentials},
+		{"no signature", url.Values{shareExpiresParam: []string{"1"}}, ErrNoCredentials},
+		{"no params", url.Values{}, ErrNoCredentials},
+	}
+	for _, test := range tests {
+		if err := authenticator.Authenticate(shareLinkRequestFor(test.query)); err != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.name, err, test.expected)
+		}
+	}
+}
+
+func TestShareLinkHandler(t *testing.T) {
+	authenticator := NewShareLinkAuthenticator("secret", time.Hour)
+	handler := ShareLinkHandler(authenticator)
+
+	tests := []struct {
+		ttl      int
+		expected time.Duration
+	}{
+		{60, time.Minute},
+		{0, time.Hour},
+		{7200, time.Hour},
+	}
+	for _, test := range tests {
+		body, _ := json.Marshal(shareLinkRequest{TTL: test.ttl})
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/share", bytes.NewReader(body)))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("ttl %d: got status %d", test.ttl, recorder.Code)
+		}
+		response := shareLinkResponse{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if ttl := time.Until(response.Expires); ttl > test.expected || ttl < test.expected-time.Minute {
+			t.Errorf("ttl %d: the link expires in %v, expected %v", test.ttl, ttl, test.expected)
+		}
+		link, err := url.Parse(response.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := authenticator.Authenticate(shareLinkRequestFor(link.Query())); err != nil {
+			t.Errorf("ttl %d: the issued link isn't valid: %v", test.ttl, err)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/share", bytes.NewBufferString("{")))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("A malformed request got status %d", recorder.Code)
+	}
+}
+
+func TestShareLinkScope(t *testing.T) {
+	shareLinks := NewShareLinkAuthenticator("secret", time.Hour)
+	tokens := NewTokenAuthenticator([]string{"token"})
+	var scope Scope
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope = RequestScope(r)
+	}), tokens, shareLinks)
+
+	handler.ServeHTTP(httptest.NewRecorder(), shareLinkRequestFor(shareLinks.Sign(time.Now().Add(time.Minute))))
+	if scope != ScopeView {
+		t.Errorf("A share link got scope %d, expected ScopeView", scope)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), shareLinkRequestFor(url.Values{"token": []string{"token"}}))
+	if scope != ScopeFull {
+		t.Errorf("A token got scope %d, expected ScopeFull", scope)
+	}
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// TokenAuthenticator accepts a fixed set of bearer tokens, sent either in the
+// Authorization header or in the token query param (needed by WebSockets)
+type TokenAuthenticator struct {
+	tokens [][]byte
+}
+
+// NewTokenAuthenticator creates a TokenAuthenticator, empty tokens are ignored
+func NewTokenAuthenticator(tokens []string) *TokenAuthenticator {
+	auth := &TokenAuthenticator{}
+	for _, token := range tokens {
+		token = strings.TrimSpace(token)
+		if token != "" {
+			auth.tokens = append(auth.tokens, []byte(token))
+		}
+	}
+	return auth
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
+
+// Authenticate checks the request token against the configured ones
+func (a *TokenAuthenticator) Authenticate(r *http.Request) error {
+	token := bearerToken(r)
+	if token == "" {
+		return ErrNoCredentials
+	}
+	for _, valid := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), valid) == 1 {
+			return nil
+		}
+	}
+	return ErrInvalidCredentials
+}
@@ -0,0 +1,180 @@
+// +build av1enc
+
+package encoders
+
+import (
+	"fmt"
+	"image"
+	"sync/atomic"
+	"unsafe"
+)
+
+/*
+#cgo pkg-config: aom
+#include <stdlib.h>
+#include <aom/aom_encoder.h>
+#include <aom/aomcx.h>
+#include "rgba_yuv.h"
+
+static int32_t av1_encode_frame(aom_codec_ctx_t *ctx, aom_image_t *img, int32_t framec, int32_t flags,
+								void *rgba, int32_t w, int32_t h, void **encoded_frame) {
+	rgba_to_yuv(img->planes, img->stride, rgba, w, h, 0);
+	if (aom_codec_encode(ctx, img, (aom_codec_pts_t)framec, 1, flags) != AOM_CODEC_OK) {
+		return -1;
+	}
+	const aom_codec_cx_pkt_t *pkt = NULL;
+	aom_codec_iter_t it = NULL;
+	while ((pkt = aom_codec_get_cx_data(ctx, &it)) != NULL) {
+		if (pkt->kind == AOM_CODEC_CX_FRAME_PKT) {
+			*encoded_frame = pkt->data.frame.buf;
+			return pkt->data.frame.sz;
+		}
+	}
+	return 0;
+}
+
+static aom_codec_err_t av1_enc_config_default(aom_codec_enc_cfg_t *cfg) {
+	return aom_codec_enc_config_default(aom_codec_av1_cx(), cfg, AOM_USAGE_REALTIME);
+}
+
+// The screen content tuning enables the palette and intra block copy tools,
+// which code text and flat areas with a fraction of the bits
+static aom_codec_err_t av1_enc_init(aom_codec_ctx_t *codec, aom_codec_enc_cfg_t *cfg) {
+	aom_codec_err_t err = aom_codec_enc_init(codec, aom_codec_av1_cx(), cfg, 0);
+	if (err != AOM_CODEC_OK) {
+		return err;
+	}
+	aom_codec_control(codec, AOME_SET_CPUUSED, 8);
+	aom_codec_control(codec, AV1E_SET_TUNE_CONTENT, AOM_CONTENT_SCREEN);
+	aom_codec_control(codec, AV1E_SET_AQ_MODE, 3);
+	aom_codec_control(codec, AV1E_SET_ROW_MT, 1);
+	aom_codec_control(codec, AV1E_SET_TILE_COLUMNS, 2);
+	aom_codec_control(codec, AV1E_SET_ENABLE_ORDER_HINT, 0);
+	aom_codec_control(codec, AV1E_SET_DELTAQ_MODE, 0);
+	return AOM_CODEC_OK;
+}
+
+*/
+import "C"
+
+//AV1Encoder AV1 encoder (libaom, realtime mode)
+type AV1Encoder struct {
+	realSize   image.Point
+	codecCtx   C.aom_codec_ctx_t
+	aomImage   *C.aom_image_t
+	frameCount uint
+	cfg        C.aom_codec_enc_cfg_t
+
+	keyFrameRequested int32
+	// kbps, applied on the next call to Encode
+	pendingBitrate int32
+}
+
+//newAV1Encoder creates the encoder, the video is always 4:2:0 (main profile)
+func newAV1Encoder(opts Options) (Encoder, error) {
+	size := opts.Size
+
+	var cfg C.aom_codec_enc_cfg_t
+	if C.av1_enc_config_default(&cfg) != 0 {
+		return nil, fmt.Errorf("Can't init default enc. config")
+	}
+	cfg.g_w = C.uint(size.X)
+	cfg.g_h = C.uint(size.Y)
+	cfg.g_timebase.num = 1
+	cfg.g_timebase.den = C.int(opts.FrameRate)
+	cfg.g_threads = 4
+	cfg.g_lag_in_frames = 0
+	cfg.g_error_resilient = 0
+	cfg.rc_end_usage = C.AOM_CBR
+	cfg.rc_target_bitrate = 500
+	if opts.Bitrate > 0 {
+		cfg.rc_target_bitrate = C.uint(opts.Bitrate / 1000)
+	}
+	cfg.rc_min_quantizer = 10
+	cfg.rc_max_quantizer = 56
+	cfg.rc_undershoot_pct = 50
+	cfg.rc_overshoot_pct = 50
+	cfg.rc_buf_initial_sz = 600
+	cfg.rc_buf_optimal_sz = 600
+	cfg.rc_buf_sz = 1000
+	cfg.kf_mode = C.AOM_KF_AUTO
+	cfg.kf_max_dist = keyFrameInterval
+
+	e := &AV1Encoder{
+		cfg:      cfg,
+		realSize: size,
+	}
+	if C.av1_enc_init(&e.codecCtx, &e.cfg) != 0 {
+		return nil, fmt.Errorf("Failed to initialize AV1 enc ctx: %s", C.GoString(C.aom_codec_error_detail(&e.codecCtx)))
+	}
+	e.aomImage = C.aom_img_alloc(nil, C.AOM_IMG_FMT_I420, C.uint(size.X), C.uint(size.Y), 16)
+	if e.aomImage == nil {
+		C.aom_codec_destroy(&e.codecCtx)
+		return nil, fmt.Errorf("Can't alloc. aom image")
+	}
+	return e, nil
+}
+
+//Encode encodes a frame into an AV1 temporal unit (low overhead OBU format)
+func (e *AV1Encoder) Encode(frame *image.RGBA) ([]byte, error) {
+	if bitrate := atomic.SwapInt32(&e.pendingBitrate, 0); bitrate > 0 {
+		e.cfg.rc_target_bitrate = C.uint(bitrate)
+		if C.aom_codec_enc_config_set(&e.codecCtx, &e.cfg) != 0 {
+			return nil, fmt.Errorf("Can't set bitrate to %d kbps", bitrate)
+		}
+	}
+	var flags C.int
+	if atomic.SwapInt32(&e.keyFrameRequested, 0) == 1 {
+		flags |= C.AOM_EFLAG_FORCE_KF
+	}
+	encodedData := unsafe.Pointer(nil)
+	frameSize := C.av1_encode_frame(
+		&e.codecCtx,
+		e.aomImage,
+		C.int(e.frameCount),
+		flags,
+		unsafe.Pointer(&frame.Pix[0]),
+		C.int(e.realSize.X),
+		C.int(e.realSize.Y),
+		&encodedData,
+	)
+	e.frameCount++
+	if frameSize < 0 {
+		return nil, fmt.Errorf("AV1 encoding failed: %s", C.GoString(C.aom_codec_error_detail(&e.codecCtx)))
+	}
+	if frameSize == 0 {
+		return nil, nil
+	}
+	return C.GoBytes(encodedData, frameSize), nil
+}
+
+//VideoSize returns the size of the encoded video
+func (e *AV1Encoder) VideoSize() (image.Point, error) {
+	return e.realSize, nil
+}
+
+//RequestKeyFrame forces a keyframe on the next call to Encode
+func (e *AV1Encoder) RequestKeyFrame() {
+	atomic.StoreInt32(&e.keyFrameRequested, 1)
+}
+
+//SetBitrate updates the target bitrate on the next call to Encode
+func (e *AV1Encoder) SetBitrate(bitrate int) error {
+	kbps := bitrate / 1000
+	if kbps <= 0 {
+		return fmt.Errorf("Invalid bitrate %d", bitrate)
+	}
+	atomic.StoreInt32(&e.pendingBitrate, int32(kbps))
+	return nil
+}
+
+//Close releases the libaom encoder
+func (e *AV1Encoder) Close() error {
+	C.aom_img_free(e.aomImage)
+	C.aom_codec_destroy(&e.codecCtx)
+	return nil
+}
+
+func init() {
+	registeredEncoders[AV1Codec] = newAV1Encoder
+}
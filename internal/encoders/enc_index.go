@@ -2,15 +2,21 @@ package encoders
 
 import (
 	"fmt"
-	"image"
 )
 
-type encoderFactory = func(size image.Point, frameRate int) (Encoder, error)
+type encoderFactory = func(opts Options) (Encoder, error)
+
+// Periodic keyframes are only a fallback, receivers ask for
+// a new one (see RequestKeyFrame) whenever they need it
+const keyFrameInterval = 300
 
 // Index of supported codecs, each encoder should register itself
 // It's implemented this way to support conditional compilation
 // of each encoder.
-var registeredEncoders = make(map[VideoCodec]encoderFactory, 2)
+var registeredEncoders = make(map[VideoCodec]encoderFactory, 4)
+
+// Opus is the only audio codec, it's set when its encoder is compiled in
+var audioEncoderFactory func(sampleRate, channels int) (AudioEncoder, error)
 
 //EncoderService creates instances of encoders
 type EncoderService struct {
@@ -22,12 +28,12 @@ func NewEncoderService() Service {
 }
 
 //NewEncoder creates an instance of an encoder of the selected codec
-func (*EncoderService) NewEncoder(codec VideoCodec, size image.Point, frameRate int) (Encoder, error) {
+func (*EncoderService) NewEncoder(codec VideoCodec, opts Options) (Encoder, error) {
 	factory, found := registeredEncoders[codec]
 	if !found {
 		return nil, fmt.Errorf("Codec not supported")
 	}
-	return factory(size, frameRate)
+	return factory(opts)
 }
 
 //Supports returns a boolean indicating if the codec is supported
@@ -35,3 +41,16 @@ func (*EncoderService) Supports(codec VideoCodec) bool {
 	_, found := registeredEncoders[codec]
 	return found
 }
+
+//NewAudioEncoder creates an instance of the Opus encoder
+func (*EncoderService) NewAudioEncoder(sampleRate, channels int) (AudioEncoder, error) {
+	if audioEncoderFactory == nil {
+		return nil, fmt.Errorf("Audio not supported")
+	}
+	return audioEncoderFactory(sampleRate, channels)
+}
+
+//SupportsAudio returns a boolean indicating if the Opus encoder is available
+func (*EncoderService) SupportsAudio() bool {
+	return audioEncoderFactory != nil
+}
@@ -3,61 +3,134 @@
 package encoders
 
 import (
-	"bytes"
 	"fmt"
 	"image"
-	"math"
+	"sync/atomic"
+	"unsafe"
 
 	"github.com/gen2brain/x264-go"
+	"github.com/gen2brain/x264-go/x264c"
 )
 
-//H264Encoder h264 encoder
+//H264Encoder h264 encoder, it drives libx264 thru x264c since the x264-go
+//encoder doesn't let us pick the type of the next picture
 type H264Encoder struct {
-	buffer   *bytes.Buffer
-	encoder  *x264.Encoder
+	encoder  *x264c.T
+	param    *x264c.Param
+	picture  *x264c.Picture
+	nals     []*x264c.Nal
+	nnals    int32
+	img      *x264.YCbCr
+	pts      int64
 	realSize image.Point
+
+	keyFrameRequested int32
+	// kbps, applied on the next call to Encode
+	pendingBitrate int32
 }
 
-const h264SupportedProfile = "3.1"
+// Target bitrate (kbps) when the options don't set one
+const h264DefaultBitrate = 1000
 
-func newH264Encoder(size image.Point, frameRate int) (Encoder, error) {
-	buffer := bytes.NewBuffer(make([]byte, 0))
-	realSize, err := findBestSizeForH264Profile(h264SupportedProfile, size)
+//newH264Encoder creates the encoder, ABR constrained by a VBV buffer of one
+//second so the bitrate can be changed while encoding (see SetBitrate)
+func newH264Encoder(encOpts Options) (Encoder, error) {
+	realSize, err := h264FrameSize(encOpts.Size)
 	if err != nil {
 		return nil, err
 	}
-	opts := x264.Options{
-		Width:     realSize.X,
-		Height:    realSize.Y,
-		FrameRate: frameRate,
-		Tune:      "zerolatency",
-		Preset:    "veryfast",
-		Profile:   "baseline",
-		LogLevel:  x264.LogWarning,
+	// cgo rejects pointers into structs holding Go pointers,
+	// the parameters and the picture get their own allocations
+	e := &H264Encoder{
+		param:    &x264c.Param{},
+		picture:  &x264c.Picture{},
+		nals:     make([]*x264c.Nal, 3),
+		img:      x264.NewYCbCr(image.Rectangle{Max: realSize}),
+		realSize: realSize,
 	}
-	encoder, err := x264.NewEncoder(buffer, &opts)
-	if err != nil {
-		return nil, err
+	param := e.param
+	if x264c.ParamDefaultPreset(param, "veryfast", "zerolatency") < 0 {
+		return nil, fmt.Errorf("x264: invalid preset/tune name")
+	}
+	param.IWidth = int32(realSize.X)
+	param.IHeight = int32(realSize.Y)
+	param.ICsp = x264c.CspI420
+	param.BVfrInput = 0
+	// SPS/PPS go along with every IDR frame so any of them can start the stream
+	param.BRepeatHeaders = 1
+	param.BAnnexb = 1
+	param.ILogLevel = x264.LogWarning
+	if encOpts.FrameRate > 0 {
+		param.IFpsNum = uint32(encOpts.FrameRate)
+		param.IFpsDen = 1
+	}
+	param.IKeyintMax = keyFrameInterval
+	kbps := int32(h264DefaultBitrate)
+	if encOpts.Bitrate > 0 {
+		kbps = int32(encOpts.Bitrate / 1000)
+	}
+	setH264Bitrate(param, kbps)
+	if x264c.ParamApplyProfile(param, "baseline") < 0 {
+		return nil, fmt.Errorf("x264: invalid profile name")
+	}
+
+	if x264c.PictureAlloc(e.picture, x264c.CspI420, param.IWidth, param.IHeight) < 0 {
+		return nil, fmt.Errorf("x264: cannot allocate picture")
+	}
+	e.encoder = x264c.EncoderOpen(param)
+	if e.encoder == nil {
+		x264c.PictureClean(e.picture)
+		return nil, fmt.Errorf("x264: cannot open the encoder")
+	}
+	return e, nil
+}
+
+//cBytes is a view of n bytes of C memory
+func cBytes(ptr unsafe.Pointer, n int) []byte {
+	return (*[1 << 30]byte)(ptr)[:n:n]
+}
+
+//copyPlane copies a plane of the converted frame into the x264 picture
+func copyPlane(dst unsafe.Pointer, dstStride int32, src []byte, srcStride, width, height int) {
+	plane := cBytes(dst, int(dstStride)*height)
+	for y := 0; y < height; y++ {
+		copy(plane[y*int(dstStride):y*int(dstStride)+width], src[y*srcStride:])
 	}
-	return &H264Encoder{
-		buffer:   buffer,
-		encoder:  encoder,
-		realSize: realSize,
-	}, nil
 }
 
 //Encode encodes a frame into a h264 payload
 func (e *H264Encoder) Encode(frame *image.RGBA) ([]byte, error) {
-	err := e.encoder.Encode(frame)
-	if err != nil {
-		return nil, err
+	if kbps := atomic.SwapInt32(&e.pendingBitrate, 0); kbps > 0 {
+		setH264Bitrate(e.param, kbps)
+		if x264c.EncoderReconfig(e.encoder, e.param) < 0 {
+			return nil, fmt.Errorf("Can't set bitrate to %d kbps", kbps)
+		}
 	}
-	err = e.encoder.Flush()
-	if err != nil {
-		return nil, err
+	e.img.ToYCbCr(frame)
+	img := &e.picture.Img
+	chroma := image.Point{(e.realSize.X + 1) / 2, (e.realSize.Y + 1) / 2}
+	copyPlane(img.Plane[0], img.IStride[0], e.img.Y, e.img.YStride, e.realSize.X, e.realSize.Y)
+	copyPlane(img.Plane[1], img.IStride[1], e.img.Cb, e.img.CStride, chroma.X, chroma.Y)
+	copyPlane(img.Plane[2], img.IStride[2], e.img.Cr, e.img.CStride, chroma.X, chroma.Y)
+
+	e.picture.IType = x264c.TypeAuto
+	if atomic.SwapInt32(&e.keyFrameRequested, 0) == 1 {
+		e.picture.IType = x264c.TypeIdr
+	}
+	e.picture.IPts = e.pts
+	e.pts++
+
+	var picOut x264c.Picture
+	size := x264c.EncoderEncode(e.encoder, e.nals, &e.nnals, e.picture, &picOut)
+	if size < 0 {
+		return nil, fmt.Errorf("x264: cannot encode picture")
+	}
+	if size == 0 {
+		return nil, nil
 	}
-	payload := e.buffer.Bytes()
-	e.buffer.Reset()
+	// The NALs are contiguous and only valid until the next call
+	payload := make([]byte, size)
+	copy(payload, cBytes(e.nals[0].PPayload, int(size)))
 	return payload, nil
 }
 
@@ -66,41 +139,34 @@ func (e *H264Encoder) VideoSize() (image.Point, error) {
 	return e.realSize, nil
 }
 
-//Close flushes and closes the inner x264 encoder
-func (e *H264Encoder) Close() error {
-	return e.encoder.Close()
+//RequestKeyFrame forces an IDR frame on the next call to Encode
+func (e *H264Encoder) RequestKeyFrame() {
+	atomic.StoreInt32(&e.keyFrameRequested, 1)
 }
 
-//findBestSizeForH264Profile finds the best match given the size constraint and H264 profile
-func findBestSizeForH264Profile(profile string, constraints image.Point) (image.Point, error) {
-	profileSizes := map[string][]image.Point{
-		"3.1": []image.Point{
-			image.Point{1280, 720},
-			image.Point{720, 576},
-			image.Point{720, 480},
-		},
-	}
-	if sizes, exists := profileSizes[profile]; exists {
-		minRatioDiff := math.MaxFloat64
-		var minRatioSize image.Point
-		for _, size := range sizes {
-			if size == constraints {
-				return size, nil
-			}
-			lowerRes := size.X < constraints.X && size.Y < constraints.Y
-			hRatio := float64(constraints.X) / float64(size.X)
-			vRatio := float64(constraints.Y) / float64(size.Y)
-			ratioDiff := math.Abs(hRatio - vRatio)
-			if lowerRes && (ratioDiff) < 0.0001 {
-				return size, nil
-			} else if ratioDiff < minRatioDiff {
-				minRatioDiff = ratioDiff
-				minRatioSize = size
-			}
-		}
-		return minRatioSize, nil
+//SetBitrate updates the target bitrate on the next call to Encode
+func (e *H264Encoder) SetBitrate(bitrate int) error {
+	kbps := bitrate / 1000
+	if kbps <= 0 {
+		return fmt.Errorf("Invalid bitrate %d", bitrate)
 	}
-	return image.Point{}, fmt.Errorf("Profile %s not supported", profile)
+	atomic.StoreInt32(&e.pendingBitrate, int32(kbps))
+	return nil
+}
+
+//setH264Bitrate switches the rate control to ABR, the VBV caps the peaks
+func setH264Bitrate(param *x264c.Param, kbps int32) {
+	param.Rc.IRcMethod = x264c.RcAbr
+	param.Rc.IBitrate = kbps
+	param.Rc.IVbvMaxBitrate = kbps
+	param.Rc.IVbvBufferSize = kbps
+}
+
+//Close releases the x264 encoder and its input picture
+func (e *H264Encoder) Close() error {
+	x264c.EncoderClose(e.encoder)
+	x264c.PictureClean(e.picture)
+	return nil
 }
 
 func init() {
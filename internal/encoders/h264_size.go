@@ -0,0 +1,64 @@
+package encoders
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// The H.264 encoder output is level 3.1, its limits are in macroblocks
+// (ITU-T H.264, table A-1). The level also bounds each dimension to
+// sqrt(8 * MaxFS) macroblocks
+const (
+	h264SupportedProfile        = "3.1"
+	h264MaxFrameMacroblocks     = 3600
+	h264MaxDimensionMacroblocks = 169
+)
+
+// h264FrameSize returns the size of the H.264 video for the requested one, it's
+// kept, rounded down to even dimensions, when it fits level 3.1. Larger sizes
+// are scaled down to the closest size of the level
+func h264FrameSize(requested image.Point) (image.Point, error) {
+	size := image.Point{requested.X &^ 1, requested.Y &^ 1}
+	if size.X < 2 || size.Y < 2 {
+		return image.Point{}, fmt.Errorf("Invalid video size %dx%d", requested.X, requested.Y)
+	}
+	macroblocks := image.Point{(size.X + 15) / 16, (size.Y + 15) / 16}
+	if macroblocks.X*macroblocks.Y <= h264MaxFrameMacroblocks &&
+		macroblocks.X <= h264MaxDimensionMacroblocks && macroblocks.Y <= h264MaxDimensionMacroblocks {
+		return size, nil
+	}
+	return findBestSizeForH264Profile(h264SupportedProfile, requested)
+}
+
+// findBestSizeForH264Profile finds the best match given the size constraint and H264 profile
+func findBestSizeForH264Profile(profile string, constraints image.Point) (image.Point, error) {
+	profileSizes := map[string][]image.Point{
+		"3.1": []image.Point{
+			image.Point{1280, 720},
+			image.Point{720, 576},
+			image.Point{720, 480},
+		},
+	}
+	if sizes, exists := profileSizes[profile]; exists {
+		minRatioDiff := math.MaxFloat64
+		var minRatioSize image.Point
+		for _, size := range sizes {
+			if size == constraints {
+				return size, nil
+			}
+			lowerRes := size.X < constraints.X && size.Y < constraints.Y
+			hRatio := float64(constraints.X) / float64(size.X)
+			vRatio := float64(constraints.Y) / float64(size.Y)
+			ratioDiff := math.Abs(hRatio - vRatio)
+			if lowerRes && (ratioDiff) < 0.0001 {
+				return size, nil
+			} else if ratioDiff < minRatioDiff {
+				minRatioDiff = ratioDiff
+				minRatioSize = size
+			}
+		}
+		return minRatioSize, nil
+	}
+	return image.Point{}, fmt.Errorf("Profile %s not supported", profile)
+}
@@ -0,0 +1,36 @@
+package encoders
+
+import (
+	"image"
+	"testing"
+)
+
+func TestH264FrameSize(t *testing.T) {
+	tests := []struct {
+		requested image.Point
+		expected  image.Point
+	}{
+		// Sizes within level 3.1 are kept
+		{image.Point{320, 180}, image.Point{320, 180}},
+		{image.Point{640, 360}, image.Point{640, 360}},
+		{image.Point{960, 540}, image.Point{960, 540}},
+		{image.Point{1280, 720}, image.Point{1280, 720}},
+		{image.Point{1024, 768}, image.Point{1024, 768}},
+		{image.Point{641, 361}, image.Point{640, 360}},
+		// Larger ones are scaled down to the level
+		{image.Point{1920, 1080}, image.Point{1280, 720}},
+		{image.Point{2560, 1440}, image.Point{1280, 720}},
+		{image.Point{1366, 768}, image.Point{1280, 720}},
+		// Too wide for the level, even if it has few macroblocks
+		{image.Point{2720, 64}, image.Point{1280, 720}},
+	}
+	for _, test := range tests {
+		size, err := h264FrameSize(test.requested)
+		if err != nil || size != test.expected {
+			t.Errorf("h264FrameSize(%v) = %v, %v, expected %v", test.requested, size, err, test.expected)
+		}
+	}
+	if _, err := h264FrameSize(image.Point{1, 100}); err == nil {
+		t.Error("A 1 pixel wide video was accepted")
+	}
+}
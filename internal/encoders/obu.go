@@ -0,0 +1,79 @@
+package encoders
+
+import (
+	"errors"
+)
+
+// AV1 OBU types used by the agent (AV1 bitstream spec, 6.2.2)
+const (
+	OBUSequenceHeader    = 1
+	OBUTemporalDelimiter = 2
+	OBUTileList          = 8
+)
+
+// OBU is an AV1 open bitstream unit
+type OBU struct {
+	Type int
+	// Header is the OBU header without the size field, its has_size_field
+	// bit is cleared
+	Header  []byte
+	Payload []byte
+}
+
+var errInvalidOBU = errors.New("Invalid AV1 OBU")
+
+// SplitOBUs parses a temporal unit in the low overhead bitstream format,
+// as output by the encoders, where every OBU has a size field
+func SplitOBUs(data []byte) ([]OBU, error) {
+	var obus []OBU
+	for len(data) > 0 {
+		headerSize := 1
+		if data[0]&0x04 != 0 {
+			headerSize = 2
+		}
+		if data[0]&0x80 != 0 || len(data) < headerSize {
+			return nil, errInvalidOBU
+		}
+		header := append([]byte(nil), data[:headerSize]...)
+		header[0] &^= 0x02
+		rest := data[headerSize:]
+		size := len(rest)
+		if data[0]&0x02 != 0 {
+			value, length := ReadLEB128(rest)
+			if length == 0 || value > uint64(len(rest)-length) {
+				return nil, errInvalidOBU
+			}
+			size = int(value)
+			rest = rest[length:]
+		}
+		obus = append(obus, OBU{
+			Type:    int(data[0] >> 3 & 0x0f),
+			Header:  header,
+			Payload: rest[:size],
+		})
+		data = rest[size:]
+	}
+	return obus, nil
+}
+
+// ReadLEB128 decodes an unsigned LEB128 value, it returns the number of
+// bytes read, zero if the value is truncated or longer than 8 bytes
+func ReadLEB128(data []byte) (uint64, int) {
+	var value uint64
+	for i := 0; i < len(data) && i < 8; i++ {
+		value |= uint64(data[i]&0x7f) << (7 * uint(i))
+		if data[i]&0x80 == 0 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// AppendLEB128 appends value encoded as unsigned LEB128
+func AppendLEB128(data []byte, value uint64) []byte {
+	for value >= 0x80 {
+		data = append(data, byte(value)|0x80)
+		value >>= 7
+	}
+	return append(data, byte(value))
+}
@@ -0,0 +1,65 @@
+// +build opusenc
+
+package encoders
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+/*
+#cgo pkg-config: opus
+#include <opus/opus.h>
+*/
+import "C"
+
+// Largest packet recommended by the Opus docs
+const maxOpusPacket = 4000
+
+//OpusEncoder Opus audio encoder
+type OpusEncoder struct {
+	encoder  *C.OpusEncoder
+	channels int
+	buffer   []byte
+}
+
+func newOpusEncoder(sampleRate, channels int) (AudioEncoder, error) {
+	var opusErr C.int
+	encoder := C.opus_encoder_create(C.opus_int32(sampleRate), C.int(channels), C.OPUS_APPLICATION_AUDIO, &opusErr)
+	if opusErr != C.OPUS_OK {
+		return nil, fmt.Errorf("Can't create Opus encoder: %s", C.GoString(C.opus_strerror(opusErr)))
+	}
+	return &OpusEncoder{
+		encoder:  encoder,
+		channels: channels,
+		buffer:   make([]byte, maxOpusPacket),
+	}, nil
+}
+
+//Encode encodes a frame of interleaved PCM samples into an Opus packet
+func (e *OpusEncoder) Encode(pcm []int16) ([]byte, error) {
+	frameSize := len(pcm) / e.channels
+	size := C.opus_encode(
+		e.encoder,
+		(*C.opus_int16)(unsafe.Pointer(&pcm[0])),
+		C.int(frameSize),
+		(*C.uchar)(unsafe.Pointer(&e.buffer[0])),
+		C.opus_int32(len(e.buffer)),
+	)
+	if size < 0 {
+		return nil, fmt.Errorf("Opus encoding failed: %s", C.GoString(C.opus_strerror(size)))
+	}
+	encoded := make([]byte, int(size))
+	copy(encoded, e.buffer)
+	return encoded, nil
+}
+
+//Close releases the inner Opus encoder
+func (e *OpusEncoder) Close() error {
+	C.opus_encoder_destroy(e.encoder)
+	return nil
+}
+
+func init() {
+	audioEncoderFactory = newOpusEncoder
+}
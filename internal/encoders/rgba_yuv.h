@@ -0,0 +1,42 @@
+#ifndef RGBA_YUV_H
+#define RGBA_YUV_H
+
+#include <stdint.h>
+
+// Writes a RGBA frame into the planes of a YUV image, BT.601 limited range.
+// With 4:2:0 each chroma sample is the average of a 2x2 block, on odd sizes
+// the last column and row are averaged with themselves
+static void rgba_to_yuv(uint8_t *const *planes, const int *strides, const uint8_t *rgba,
+						int width, int height, int full_chroma) {
+	for (int y = 0; y < height; ++y) {
+		const uint8_t *src = rgba + 4 * width * y;
+		uint8_t *dst_y = planes[0] + strides[0] * y;
+		for (int x = 0; x < width; ++x) {
+			int r = src[4 * x], g = src[4 * x + 1], b = src[4 * x + 2];
+			dst_y[x] = ((66*r + 129*g + 25*b + 128) >> 8) + 16;
+		}
+		if (full_chroma) {
+			uint8_t *dst_u = planes[1] + strides[1] * y;
+			uint8_t *dst_v = planes[2] + strides[2] * y;
+			for (int x = 0; x < width; ++x) {
+				int r = src[4 * x], g = src[4 * x + 1], b = src[4 * x + 2];
+				dst_u[x] = ((-38*r - 74*g + 112*b + 128) >> 8) + 128;
+				dst_v[x] = ((112*r - 94*g - 18*b + 128) >> 8) + 128;
+			}
+		} else if (y % 2 == 0) {
+			const uint8_t *next = y + 1 < height ? src + 4 * width : src;
+			uint8_t *dst_u = planes[1] + strides[1] * (y / 2);
+			uint8_t *dst_v = planes[2] + strides[2] * (y / 2);
+			for (int x = 0; x < width; x += 2) {
+				int x1 = x + 1 < width ? x + 1 : x;
+				int r = (src[4*x] + src[4*x1] + next[4*x] + next[4*x1]) / 4;
+				int g = (src[4*x + 1] + src[4*x1 + 1] + next[4*x + 1] + next[4*x1 + 1]) / 4;
+				int b = (src[4*x + 2] + src[4*x1 + 2] + next[4*x + 2] + next[4*x1 + 2]) / 4;
+				dst_u[x / 2] = ((-38*r - 74*g + 112*b + 128) >> 8) + 128;
+				dst_v[x / 2] = ((112*r - 94*g - 18*b + 128) >> 8) + 128;
+			}
+		}
+	}
+}
+
+#endif
@@ -5,10 +5,31 @@ import (
 	"io"
 )
 
+// Options configure a new encoder
+type Options struct {
+	Size      image.Point
+	FrameRate int
+	// Bitrate is the initial target bitrate (bits per second),
+	// zero keeps the encoder default
+	Bitrate int
+	// Chroma444 keeps the full chroma resolution (4:4:4) so colored
+	// text stays sharp, only the VP9 encoder supports it
+	Chroma444 bool
+}
+
 // Service creates encoder instances
 type Service interface {
-	NewEncoder(codec VideoCodec, size image.Point, frameRate int) (Encoder, error)
+	NewEncoder(codec VideoCodec, opts Options) (Encoder, error)
 	Supports(codec VideoCodec) bool
+	// NewAudioEncoder creates an Opus encoder
+	NewAudioEncoder(sampleRate, channels int) (AudioEncoder, error)
+	SupportsAudio() bool
+}
+
+// AudioEncoder takes interleaved 16 bit PCM frames and encodes them
+type AudioEncoder interface {
+	io.Closer
+	Encode(pcm []int16) ([]byte, error)
 }
 
 // Encoder takes an image/frame and encodes it
@@ -16,9 +37,15 @@ type Encoder interface {
 	io.Closer
 	Encode(*image.RGBA) ([]byte, error)
 	VideoSize() (image.Point, error)
+	// RequestKeyFrame makes the next encoded frame a keyframe,
+	// it's safe to call it concurrently with Encode
+	RequestKeyFrame()
+	// SetBitrate changes the target bitrate (bits per second), like
+	// RequestKeyFrame it's safe to call it concurrently with Encode
+	SetBitrate(bitrate int) error
 }
 
-//VideoCodec can be either h264 or vp8
+//VideoCodec can be either h264, vp8, vp9 or av1
 type VideoCodec = int
 
 const (
@@ -28,4 +55,8 @@ const (
 	H264Codec
 	//VP8Codec vp8
 	VP8Codec
+	//VP9Codec vp9
+	VP9Codec
+	//AV1Codec av1
+	AV1Codec
 )
@@ -0,0 +1,187 @@
+// +build vp9enc
+
+package encoders
+
+import (
+	"fmt"
+	"image"
+	"sync/atomic"
+	"unsafe"
+)
+
+/*
+#cgo pkg-config: vpx
+#include <stdlib.h>
+#include <string.h>
+#include <vpx/vpx_encoder.h>
+#include <vpx/vp8cx.h>
+#include "rgba_yuv.h"
+
+static int32_t vp9_encode_frame(vpx_codec_ctx_t *ctx, vpx_image_t *img, int32_t framec, int32_t flags,
+								void *rgba, int32_t w, int32_t h, void **encoded_frame) {
+	rgba_to_yuv(img->planes, img->stride, rgba, w, h, img->x_chroma_shift == 0);
+	if (vpx_codec_encode(ctx, img, (vpx_codec_pts_t)framec, 1, flags, VPX_DL_REALTIME) != 0) {
+		return -1;
+	}
+	const vpx_codec_cx_pkt_t *pkt = NULL;
+	vpx_codec_iter_t it = NULL;
+	while ((pkt = vpx_codec_get_cx_data(ctx, &it)) != NULL) {
+		if (pkt->kind == VPX_CODEC_CX_FRAME_PKT) {
+			*encoded_frame = pkt->data.frame.buf;
+			return pkt->data.frame.sz;
+		}
+	}
+	return 0;
+}
+
+static vpx_codec_err_t vp9_enc_config_default(vpx_codec_enc_cfg_t *cfg) {
+	return vpx_codec_enc_config_default(vpx_codec_vp9_cx(), cfg, 0);
+}
+
+// Realtime settings tuned for screen content: the screen content mode
+// favors sharp edges and flat areas over motion, cyclic refresh AQ keeps
+// the frames small without periodic keyframes
+static vpx_codec_err_t vp9_enc_init(vpx_codec_ctx_t *codec, vpx_codec_enc_cfg_t *cfg) {
+	vpx_codec_err_t err = vpx_codec_enc_init(codec, vpx_codec_vp9_cx(), cfg, 0);
+	if (err != VPX_CODEC_OK) {
+		return err;
+	}
+	vpx_codec_control(codec, VP8E_SET_CPUUSED, 7);
+	vpx_codec_control(codec, VP9E_SET_TUNE_CONTENT, VP9E_CONTENT_SCREEN);
+	vpx_codec_control(codec, VP9E_SET_AQ_MODE, 3);
+	vpx_codec_control(codec, VP9E_SET_ROW_MT, 1);
+	vpx_codec_control(codec, VP9E_SET_TILE_COLUMNS, 2);
+	vpx_codec_control(codec, VP8E_SET_STATIC_THRESHOLD, 1);
+	vpx_codec_control(codec, VP8E_SET_MAX_INTRA_BITRATE_PCT, 900);
+	return VPX_CODEC_OK;
+}
+
+*/
+import "C"
+
+//VP9Encoder VP9 encoder
+type VP9Encoder struct {
+	realSize   image.Point
+	codecCtx   C.vpx_codec_ctx_t
+	vpxImage   *C.vpx_image_t
+	frameCount uint
+	cfg        C.vpx_codec_enc_cfg_t
+
+	keyFrameRequested int32
+	// kbps, applied on the next call to Encode
+	pendingBitrate int32
+}
+
+//newVP9Encoder creates the encoder, profile 1 (4:4:4) if opts.Chroma444 is set
+func newVP9Encoder(opts Options) (Encoder, error) {
+	size := opts.Size
+
+	var cfg C.vpx_codec_enc_cfg_t
+	if C.vp9_enc_config_default(&cfg) != 0 {
+		return nil, fmt.Errorf("Can't init default enc. config")
+	}
+	format := C.vpx_img_fmt_t(C.VPX_IMG_FMT_I420)
+	if opts.Chroma444 {
+		cfg.g_profile = 1
+		format = C.VPX_IMG_FMT_I444
+	}
+	cfg.g_w = C.uint(size.X)
+	cfg.g_h = C.uint(size.Y)
+	cfg.g_timebase.num = 1
+	cfg.g_timebase.den = C.int(opts.FrameRate)
+	cfg.g_threads = 4
+	cfg.g_lag_in_frames = 0
+	cfg.g_error_resilient = 1
+	cfg.rc_end_usage = C.VPX_CBR
+	cfg.rc_target_bitrate = 1000
+	if opts.Bitrate > 0 {
+		cfg.rc_target_bitrate = C.uint(opts.Bitrate / 1000)
+	}
+	cfg.rc_min_quantizer = 4
+	cfg.rc_max_quantizer = 56
+	cfg.rc_undershoot_pct = 50
+	cfg.rc_overshoot_pct = 50
+	cfg.rc_buf_initial_sz = 500
+	cfg.rc_buf_optimal_sz = 600
+	cfg.rc_buf_sz = 1000
+	cfg.kf_mode = C.VPX_KF_AUTO
+	cfg.kf_max_dist = keyFrameInterval
+
+	e := &VP9Encoder{
+		cfg:      cfg,
+		realSize: size,
+	}
+	if C.vp9_enc_init(&e.codecCtx, &e.cfg) != 0 {
+		return nil, fmt.Errorf("Failed to initialize VP9 enc ctx: %s", C.GoString(C.vpx_codec_error_detail(&e.codecCtx)))
+	}
+	e.vpxImage = C.vpx_img_alloc(nil, format, C.uint(size.X), C.uint(size.Y), 16)
+	if e.vpxImage == nil {
+		C.vpx_codec_destroy(&e.codecCtx)
+		return nil, fmt.Errorf("Can't alloc. vpx image")
+	}
+	return e, nil
+}
+
+//Encode encodes a frame into a VP9 payload
+func (e *VP9Encoder) Encode(frame *image.RGBA) ([]byte, error) {
+	if bitrate := atomic.SwapInt32(&e.pendingBitrate, 0); bitrate > 0 {
+		e.cfg.rc_target_bitrate = C.uint(bitrate)
+		if C.vpx_codec_enc_config_set(&e.codecCtx, &e.cfg) != 0 {
+			return nil, fmt.Errorf("Can't set bitrate to %d kbps", bitrate)
+		}
+	}
+	var flags C.int
+	if atomic.SwapInt32(&e.keyFrameRequested, 0) == 1 {
+		flags |= C.VPX_EFLAG_FORCE_KF
+	}
+	encodedData := unsafe.Pointer(nil)
+	frameSize := C.vp9_encode_frame(
+		&e.codecCtx,
+		e.vpxImage,
+		C.int(e.frameCount),
+		flags,
+		unsafe.Pointer(&frame.Pix[0]),
+		C.int(e.realSize.X),
+		C.int(e.realSize.Y),
+		&encodedData,
+	)
+	e.frameCount++
+	if frameSize < 0 {
+		return nil, fmt.Errorf("VP9 encoding failed: %s", C.GoString(C.vpx_codec_error_detail(&e.codecCtx)))
+	}
+	if frameSize == 0 {
+		return nil, nil
+	}
+	return C.GoBytes(encodedData, frameSize), nil
+}
+
+//VideoSize returns the size of the encoded video
+func (e *VP9Encoder) VideoSize() (image.Point, error) {
+	return e.realSize, nil
+}
+
+//RequestKeyFrame forces a keyframe on the next call to Encode
+func (e *VP9Encoder) RequestKeyFrame() {
+	atomic.StoreInt32(&e.keyFrameRequested, 1)
+}
+
+//SetBitrate updates the target bitrate on the next call to Encode
+func (e *VP9Encoder) SetBitrate(bitrate int) error {
+	kbps := bitrate / 1000
+	if kbps <= 0 {
+		return fmt.Errorf("Invalid bitrate %d", bitrate)
+	}
+	atomic.StoreInt32(&e.pendingBitrate, int32(kbps))
+	return nil
+}
+
+//Close releases the libvpx encoder
+func (e *VP9Encoder) Close() error {
+	C.vpx_img_free(e.vpxImage)
+	C.vpx_codec_destroy(&e.codecCtx)
+	return nil
+}
+
+func init() {
+	registeredEncoders[VP9Codec] = newVP9Encoder
+}
@@ -0,0 +1,167 @@
+package raudio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+)
+
+// Frequency and amplitude of the test tone
+const (
+	toneFrequency = 440
+	toneAmplitude = 0.2 * math.MaxInt16
+)
+
+// pacedGrabber delivers generated frames in real time
+type pacedGrabber struct {
+	next   func() []int16
+	frames chan []int16
+	stop   chan struct{}
+}
+
+func newPacedGrabber(next func() []int16) *pacedGrabber {
+	return &pacedGrabber{
+		next:   next,
+		frames: make(chan []int16, 1),
+		stop:   make(chan struct{}),
+	}
+}
+
+// Start begins producing a frame every 20 ms
+func (g *pacedGrabber) Start() {
+	go func() {
+		defer close(g.frames)
+		ticker := time.NewTicker(time.Second * FrameSamples / SampleRate)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-g.stop:
+				return
+			case <-ticker.C:
+				select {
+				case g.frames <- g.next():
+				case <-g.stop:
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Frames returns the generated audio
+func (g *pacedGrabber) Frames() <-chan []int16 {
+	return g.frames
+}
+
+// Stop ends the generation
+func (g *pacedGrabber) Stop() {
+	close(g.stop)
+}
+
+// ToneProvider generates a sine wave, for testing without a sound server
+type ToneProvider struct{}
+
+// NewToneProvider returns a test tone provider
+func NewToneProvider() Service {
+	return &ToneProvider{}
+}
+
+// CreateAudioGrabber creates a grabber that generates the tone
+func (*ToneProvider) CreateAudioGrabber() (AudioGrabber, error) {
+	position := 0
+	return newPacedGrabber(func() []int16 {
+		frame := make([]int16, FrameSamples*Channels)
+		for i := 0; i < FrameSamples; i++ {
+			value := int16(toneAmplitude * math.Sin(2*math.Pi*toneFrequency*float64(position)/SampleRate))
+			position = (position + 1) % SampleRate
+			for c := 0; c < Channels; c++ {
+				frame[i*Channels+c] = value
+			}
+		}
+		return frame
+	}), nil
+}
+
+// WAVProvider plays a WAV file in a loop
+type WAVProvider struct {
+	samples []int16
+}
+
+// NewWAVProvider loads a 48 kHz 16 bit PCM WAV file, mono or stereo
+func NewWAVProvider(path string) (Service, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := decodeWAV(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &WAVProvider{samples: samples}, nil
+}
+
+// decodeWAV returns the interleaved stereo samples of a WAV file
+func decodeWAV(data []byte) ([]int16, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("Not a WAV file")
+	}
+	var channels int
+	for offset := 12; offset+8 <= len(data); {
+		chunkID := string(data[offset : offset+4])
+		chunkSize := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		body := data[offset+8:]
+		if chunkSize > len(body) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		body = body[:chunkSize]
+		switch chunkID {
+		case "fmt ":
+			if len(body) < 16 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			format := binary.LittleEndian.Uint16(body[0:])
+			channels = int(binary.LittleEndian.Uint16(body[2:]))
+			rate := binary.LittleEndian.Uint32(body[4:])
+			bits := binary.LittleEndian.Uint16(body[14:])
+			if format != 1 || bits != 16 || rate != SampleRate || channels < 1 || channels > 2 {
+				return nil, fmt.Errorf("Only 48 kHz 16 bit PCM mono or stereo files are supported")
+			}
+		case "data":
+			if channels == 0 {
+				return nil, fmt.Errorf("Missing fmt chunk")
+			}
+			count := len(body) / (2 * channels)
+			samples := make([]int16, count*Channels)
+			for i := 0; i < count; i++ {
+				for c := 0; c < Channels; c++ {
+					// Mono files are duplicated in both channels
+					source := i*channels + c%channels
+					samples[i*Channels+c] = int16(binary.LittleEndian.Uint16(body[source*2:]))
+				}
+			}
+			if len(samples) == 0 {
+				return nil, fmt.Errorf("The file has no samples")
+			}
+			return samples, nil
+		}
+		// Chunks are padded to an even size
+		offset += 8 + chunkSize + chunkSize%2
+	}
+	return nil, fmt.Errorf("Missing data chunk")
+}
+
+// CreateAudioGrabber creates a grabber that loops over the file
+func (p *WAVProvider) CreateAudioGrabber() (AudioGrabber, error) {
+	position := 0
+	return newPacedGrabber(func() []int16 {
+		frame := make([]int16, FrameSamples*Channels)
+		for i := range frame {
+			frame[i] = p.samples[position]
+			position = (position + 1) % len(p.samples)
+		}
+		return frame
+	}), nil
+}
@@ -0,0 +1,103 @@
+package raudio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"log"
+	"os/exec"
+	"strconv"
+)
+
+// Source that captures whatever the default output device plays, it works
+// with PulseAudio and with PipeWire thru pipewire-pulse
+const pulseMonitorDevice = "@DEFAULT_MONITOR@"
+
+// PulseProvider captures the default sink monitor with parec
+type PulseProvider struct {
+	device string
+}
+
+// NewPulseProvider returns a provider for the device, or the default
+// sink monitor if device is empty
+func NewPulseProvider(device string) (Service, error) {
+	if _, err := exec.LookPath("parec"); err != nil {
+		return nil, err
+	}
+	if device == "" {
+		device = pulseMonitorDevice
+	}
+	return &PulseProvider{device: device}, nil
+}
+
+// CreateAudioGrabber creates a grabber that runs its own parec process
+func (p *PulseProvider) CreateAudioGrabber() (AudioGrabber, error) {
+	return &pulseGrabber{
+		device: p.device,
+		frames: make(chan []int16, 1),
+		stop:   make(chan struct{}),
+	}, nil
+}
+
+type pulseGrabber struct {
+	device string
+	frames chan []int16
+	stop   chan struct{}
+	cmd    *exec.Cmd
+}
+
+// Start launches parec and reads its raw output until stopped
+func (g *pulseGrabber) Start() {
+	g.cmd = exec.Command("parec",
+		"--device="+g.device,
+		"--format=s16le",
+		"--rate="+strconv.Itoa(SampleRate),
+		"--channels="+strconv.Itoa(Channels),
+		// Keep the capture latency in line with the frame size
+		"--latency-msec=20",
+	)
+	stdout, err := g.cmd.StdoutPipe()
+	if err != nil {
+		log.Printf("Audio: %v", err)
+		close(g.frames)
+		return
+	}
+	if err := g.cmd.Start(); err != nil {
+		log.Printf("Audio: can't start parec: %v", err)
+		close(g.frames)
+		return
+	}
+	go func() {
+		defer close(g.frames)
+		defer g.cmd.Wait()
+		reader := bufio.NewReader(stdout)
+		buffer := make([]byte, FrameSamples*Channels*2)
+		for {
+			if _, err := io.ReadFull(reader, buffer); err != nil {
+				return
+			}
+			frame := make([]int16, FrameSamples*Channels)
+			for i := range frame {
+				frame[i] = int16(binary.LittleEndian.Uint16(buffer[i*2:]))
+			}
+			select {
+			case g.frames <- frame:
+			case <-g.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Frames returns the captured audio
+func (g *pulseGrabber) Frames() <-chan []int16 {
+	return g.frames
+}
+
+// Stop kills parec, which ends the read loop
+func (g *pulseGrabber) Stop() {
+	close(g.stop)
+	if g.cmd != nil && g.cmd.Process != nil {
+		g.cmd.Process.Kill()
+	}
+}
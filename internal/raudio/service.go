@@ -0,0 +1,23 @@
+package raudio
+
+// Audio format produced by every grabber, it's what Opus expects
+// for WebRTC: 48 kHz stereo, delivered in 20 ms frames
+const (
+	SampleRate = 48000
+	Channels   = 2
+	// FrameSamples is the number of samples per channel in each frame
+	FrameSamples = SampleRate / 50
+)
+
+// AudioGrabber captures audio as interleaved signed 16 bit PCM frames
+// of FrameSamples samples per channel
+type AudioGrabber interface {
+	Start()
+	Frames() <-chan []int16
+	Stop()
+}
+
+// Service creates audio grabbers
+type Service interface {
+	CreateAudioGrabber() (AudioGrabber, error)
+}
@@ -0,0 +1,89 @@
+package rdisplay
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"sync"
+
+	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/xfixes"
+)
+
+// XCursorTracker reads the cursor thru the XFixes extension
+type XCursorTracker struct {
+	mutex  sync.Mutex
+	conn   *xgb.Conn
+	cursor *Cursor
+	// XFixes reports the position in root window coordinates
+	origin image.Point
+}
+
+// CreateCursorTracker connects to the X server and checks XFixes is available
+func (*XVideoProvider) CreateCursorTracker() (CursorTracker, error) {
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return nil, err
+	}
+	if err = xfixes.Init(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("XFixes extension not available: %v", err)
+	}
+	// The version must be negotiated before using any other request
+	if _, err = xfixes.QueryVersion(conn, 4, 0).Reply(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &XCursorTracker{conn: conn, origin: rootOrigin(conn)}, nil
+}
+
+// Cursor returns the current cursor, the image is only converted when
+// it changes so the result must not be modified
+func (t *XCursorTracker) Cursor() (*Cursor, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	reply, err := xfixes.GetCursorImage(t.conn).Reply()
+	if err != nil {
+		return nil, err
+	}
+	cursor := &Cursor{
+		Position: image.Point{int(reply.X), int(reply.Y)}.Sub(t.origin),
+		Hotspot:  image.Point{int(reply.Xhot), int(reply.Yhot)},
+		Serial:   reply.CursorSerial,
+	}
+	if t.cursor != nil && t.cursor.Serial == reply.CursorSerial {
+		cursor.Image = t.cursor.Image
+	} else {
+		cursor.Image = cursorImage(int(reply.Width), int(reply.Height), reply.CursorImage)
+	}
+	t.cursor = cursor
+	return cursor, nil
+}
+
+// cursorImage converts the XFixes pixels, premultiplied ARGB like image.RGBA
+func cursorImage(width, height int, pixels []uint32) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i, pixel := range pixels {
+		if i >= width*height {
+			break
+		}
+		img.Pix[i*4] = byte(pixel >> 16)
+		img.Pix[i*4+1] = byte(pixel >> 8)
+		img.Pix[i*4+2] = byte(pixel)
+		img.Pix[i*4+3] = byte(pixel >> 24)
+	}
+	return img
+}
+
+// Close closes the X connection
+func (t *XCursorTracker) Close() error {
+	t.conn.Close()
+	return nil
+}
+
+// DrawCursor composites the cursor into a frame capturing bounds
+func DrawCursor(frame *image.RGBA, bounds image.Rectangle, cursor *Cursor) {
+	origin := cursor.Position.Sub(cursor.Hotspot).Sub(bounds.Min).Add(frame.Rect.Min)
+	target := cursor.Image.Rect.Add(origin)
+	draw.Draw(frame, target, cursor.Image, image.Point{}, draw.Over)
+}
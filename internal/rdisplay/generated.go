@@ -0,0 +1,221 @@
+package rdisplay
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"time"
+)
+
+// Size of the test pattern when none is given
+var defaultTestSize = image.Point{1280, 720}
+
+// pacedGrabber delivers generated frames in real time, next gets the time
+// elapsed since the start
+type pacedGrabber struct {
+	fps    int
+	screen Screen
+	next   func(elapsed time.Duration) *image.RGBA
+	frames chan *image.RGBA
+	stop   chan struct{}
+	// release is called once the generation ends, if set
+	release func()
+}
+
+func newPacedGrabber(screen Screen, fps int, next func(time.Duration) *image.RGBA) *pacedGrabber {
+	return &pacedGrabber{
+		fps:    fps,
+		screen: screen,
+		next:   next,
+		frames: make(chan *image.RGBA),
+		stop:   make(chan struct{}),
+	}
+}
+
+// Start begins producing frames at the grabber frame rate
+func (g *pacedGrabber) Start() {
+	go func() {
+		defer close(g.frames)
+		if g.release != nil {
+			defer g.release()
+		}
+		ticker := time.NewTicker(time.Second / time.Duration(g.fps))
+		defer ticker.Stop()
+		startedAt := time.Now()
+		for {
+			frame := g.next(time.Since(startedAt))
+			if frame == nil {
+				return
+			}
+			select {
+			case g.frames <- frame:
+			case <-g.stop:
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-g.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Frames returns the generated video
+func (g *pacedGrabber) Frames() <-chan *image.RGBA {
+	return g.frames
+}
+
+// Stop ends the generation
+func (g *pacedGrabber) Stop() {
+	close(g.stop)
+}
+
+// Screen returns the generated screen
+func (g *pacedGrabber) Screen() *Screen {
+	return &g.screen
+}
+
+// Fps returns the frames per sec. we're generating
+func (g *pacedGrabber) Fps() int {
+	return g.fps
+}
+
+// singleScreen describes the only screen of the generated sources, the
+// encoders need even dimensions
+func singleScreen(name string, size image.Point, refreshRate float64) Screen {
+	return Screen{
+		Index:       0,
+		Bounds:      image.Rectangle{Max: image.Point{size.X &^ 1, size.Y &^ 1}},
+		Name:        name,
+		Primary:     true,
+		RefreshRate: refreshRate,
+		Scale:       1,
+	}
+}
+
+// sourceArea checks the screen to capture is within the generated one, the
+// screen bounds may be narrowed to capture a region of it (see snapshots)
+func sourceArea(source Screen, screen Screen) (image.Rectangle, error) {
+	if screen.Bounds.Empty() || !screen.Bounds.In(source.Bounds) {
+		return image.Rectangle{}, fmt.Errorf("Area %v is out of the %v screen", screen.Bounds, source.Bounds)
+	}
+	return screen.Bounds, nil
+}
+
+// cropFrame copies the area of a generated frame, like the X grabbers the
+// result has the size of the area and starts at the origin
+func cropFrame(frame *image.RGBA, area image.Rectangle) *image.RGBA {
+	if area == frame.Rect {
+		return frame
+	}
+	cropped := image.NewRGBA(image.Rectangle{Max: area.Size()})
+	draw.Draw(cropped, cropped.Rect, frame, area.Min, draw.Src)
+	return cropped
+}
+
+// TestPatternProvider generates moving color bars with a clock and a frame
+// counter, for testing without a display
+type TestPatternProvider struct {
+	screen Screen
+}
+
+// NewTestPatternProvider returns a test pattern provider, a zero size
+// selects 1280x720
+func NewTestPatternProvider(size image.Point) (Service, error) {
+	if size == (image.Point{}) {
+		size = defaultTestSize
+	}
+	if size.X < 64 || size.Y < 64 {
+		return nil, fmt.Errorf("Test pattern size %dx%d is too small", size.X, size.Y)
+	}
+	return &TestPatternProvider{screen: singleScreen("testsrc", size, 0)}, nil
+}
+
+// Screens returns the generated screen
+func (p *TestPatternProvider) Screens() ([]Screen, error) {
+	return []Screen{p.screen}, nil
+}
+
+// Colors of the bars, as in the SMPTE pattern
+var testBars = []color.RGBA{
+	{192, 192, 192, 255},
+	{192, 192, 0, 255},
+	{0, 192, 192, 255},
+	{0, 192, 0, 255},
+	{192, 0, 192, 255},
+	{192, 0, 0, 255},
+	{0, 0, 192, 255},
+}
+
+// CreateScreenGrabber creates a grabber that draws the pattern, cropped
+// to the screen bounds
+func (p *TestPatternProvider) CreateScreenGrabber(screen Screen, fps int) (ScreenGrabber, error) {
+	area, err := sourceArea(p.screen, screen)
+	if err != nil {
+		return nil, err
+	}
+	bounds := p.screen.Bounds
+	frameCount := 0
+	return newPacedGrabber(screen, fps, func(elapsed time.Duration) *image.RGBA {
+		frame := image.NewRGBA(bounds)
+		barWidth := (bounds.Dx() + len(testBars) - 1) / len(testBars)
+		// The bars scroll a full bar per second
+		shift := int(elapsed * time.Duration(barWidth) / time.Second)
+		for x := 0; x < bounds.Dx(); x++ {
+			bar := testBars[((x+shift)/barWidth)%len(testBars)]
+			draw.Draw(frame, image.Rect(x, 0, x+1, bounds.Dy()), image.NewUniform(bar), image.Point{}, draw.Src)
+		}
+		scale := clampScale(bounds.Dy() / 90)
+		clock := time.Now().Format("15:04:05.000")
+		drawText(frame, image.Point{scale * 4, scale * 4}, clock, scale)
+		drawText(frame, image.Point{scale * 4, scale * 14}, fmt.Sprintf("%d", frameCount), scale)
+		frameCount++
+		return cropFrame(frame, area)
+	}), nil
+}
+
+func clampScale(scale int) int {
+	if scale < 1 {
+		return 1
+	}
+	return scale
+}
+
+// 3x5 glyphs of the characters used by the test pattern, one row per
+// string, '#' is a lit pixel
+var testGlyphs = map[rune][5]string{
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", "###", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", "..#", "..#", "..#"},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+	':': {"...", ".#.", "...", ".#.", "..."},
+	'.': {"...", "...", "...", "...", ".#."},
+}
+
+// drawText draws white text on a black box, each glyph pixel is a
+// scale x scale square
+func drawText(frame *image.RGBA, at image.Point, text string, scale int) {
+	box := image.Rect(at.X-scale, at.Y-scale, at.X+len(text)*4*scale, at.Y+6*scale)
+	draw.Draw(frame, box, image.Black, image.Point{}, draw.Src)
+	for i, char := range []rune(text) {
+		glyph := testGlyphs[char]
+		for row, line := range glyph {
+			for col, pixel := range line {
+				if pixel != '#' {
+					continue
+				}
+				x := at.X + (i*4+col)*scale
+				y := at.Y + row*scale
+				draw.Draw(frame, image.Rect(x, y, x+scale, y+scale), image.White, image.Point{}, draw.Src)
+			}
+		}
+	}
+}
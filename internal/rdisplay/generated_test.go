@@ -0,0 +1,117 @@
+package rdisplay
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// firstFrame starts a grabber of the screen and returns its first frame
+func firstFrame(t *testing.T, service Service, screen Screen) *image.RGBA {
+	t.Helper()
+	grabber, err := service.CreateScreenGrabber(screen, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grabber.Start()
+	defer grabber.Stop()
+	select {
+	case frame, ok := <-grabber.Frames():
+		if !ok {
+			t.Fatal("The grabber stopped without frames")
+		}
+		return frame
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for a frame")
+	}
+	return nil
+}
+
+// gradient has a different color on every pixel
+func gradient(size image.Point) *image.RGBA {
+	img := image.NewRGBA(image.Rectangle{Max: size})
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			img.SetRGBA(x, y, color.RGBA{byte(x), byte(y), byte(x + y), 255})
+		}
+	}
+	return img
+}
+
+// checkCrop grabs a region of the only screen of the service and compares
+// it with the same area of the full frame
+func checkCrop(t *testing.T, service Service) {
+	t.Helper()
+	screens, err := service.Screens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	screen := screens[0]
+	full := firstFrame(t, service, screen)
+
+	region := screen
+	region.Bounds = image.Rect(10, 6, 31, 17)
+	frame := firstFrame(t, service, region)
+	if frame.Rect != (image.Rectangle{Max: region.Bounds.Size()}) {
+		t.Fatalf("Got a %v frame for the %v region", frame.Rect, region.Bounds)
+	}
+	for y := 0; y < frame.Rect.Dy(); y++ {
+		for x := 0; x < frame.Rect.Dx(); x++ {
+			got, expected := frame.RGBAAt(x, y), full.RGBAAt(x+10, y+6)
+			if got != expected {
+				t.Fatalf("Pixel (%d, %d) of the region is %v, expected %v", x, y, got, expected)
+			}
+		}
+	}
+
+	outside := screen
+	outside.Bounds = screen.Bounds.Add(image.Point{1, 0})
+	if _, err := service.CreateScreenGrabber(outside, 10); err == nil {
+		t.Errorf("A grabber of %v was created for a %v screen", outside.Bounds, screen.Bounds)
+	}
+}
+
+func TestPNGSequenceCrop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pngseq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file, err := os.Create(filepath.Join(dir, "0001.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(file, gradient(image.Point{64, 48}))
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := NewPNGSequenceProvider(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCrop(t, service)
+}
+
+func TestTestPatternBounds(t *testing.T) {
+	service, err := NewTestPatternProvider(image.Point{320, 180})
+	if err != nil {
+		t.Fatal(err)
+	}
+	screens, _ := service.Screens()
+	// The pattern moves, only the size of the region can be compared
+	region := screens[0]
+	region.Bounds = image.Rect(100, 50, 201, 91)
+	if frame := firstFrame(t, service, region); frame.Rect.Size() != region.Bounds.Size() {
+		t.Errorf("Got a %v frame for the %v region", frame.Rect, region.Bounds)
+	}
+	region.Bounds = image.Rect(300, 0, 340, 10)
+	if _, err := service.CreateScreenGrabber(region, 10); err == nil {
+		t.Errorf("A grabber of %v was created for a %v screen", region.Bounds, screens[0].Bounds)
+	}
+}
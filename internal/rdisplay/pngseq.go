@@ -0,0 +1,82 @@
+package rdisplay
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// PNGSequenceProvider plays the PNG files of a directory in name order and
+// in a loop, one per frame. They're decoded upfront so it's meant for short
+// sequences
+type PNGSequenceProvider struct {
+	screen Screen
+	images []*image.RGBA
+}
+
+// NewPNGSequenceProvider loads the PNG files of a directory, the first one
+// sets the screen size
+func NewPNGSequenceProvider(dir string) (Service, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.png"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%s has no PNG files", dir)
+	}
+	sort.Strings(paths)
+	p := &PNGSequenceProvider{}
+	for _, path := range paths {
+		img, err := loadPNG(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if len(p.images) == 0 {
+			p.screen = singleScreen(filepath.Base(dir), img.Bounds().Size(), 0)
+		}
+		// Every frame gets the size of the screen, anchored at the top left corner
+		frame := image.NewRGBA(p.screen.Bounds)
+		draw.Draw(frame, frame.Rect, img, img.Bounds().Min, draw.Src)
+		p.images = append(p.images, frame)
+	}
+	return p, nil
+}
+
+func loadPNG(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return png.Decode(file)
+}
+
+// Screens returns the screen of the sequence
+func (p *PNGSequenceProvider) Screens() ([]Screen, error) {
+	return []Screen{p.screen}, nil
+}
+
+// CreateScreenGrabber creates a grabber that plays the sequence at fps,
+// cropped to the screen bounds. The images are shared, consumers only
+// read the frames
+func (p *PNGSequenceProvider) CreateScreenGrabber(screen Screen, fps int) (ScreenGrabber, error) {
+	area, err := sourceArea(p.screen, screen)
+	if err != nil {
+		return nil, err
+	}
+	images := make([]*image.RGBA, len(p.images))
+	for i, img := range p.images {
+		images[i] = cropFrame(img, area)
+	}
+	index := 0
+	return newPacedGrabber(screen, fps, func(time.Duration) *image.RGBA {
+		frame := images[index]
+		index = (index + 1) % len(images)
+		return frame
+	}), nil
+}
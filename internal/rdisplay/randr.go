@@ -0,0 +1,162 @@
+package rdisplay
+
+import (
+	"image"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/randr"
+	"github.com/BurntSushi/xgb/xproto"
+)
+
+// Xft.dpi that corresponds to a scale factor of 1
+const baseDPI = 96
+
+// randrOutput is the RandR state of an output driving a CRTC
+type randrOutput struct {
+	bounds      image.Rectangle
+	name        string
+	primary     bool
+	refreshRate float64
+}
+
+// refreshRate computes the vertical refresh rate (Hz) of a mode
+func refreshRate(mode randr.ModeInfo) float64 {
+	if mode.Htotal == 0 || mode.Vtotal == 0 {
+		return 0
+	}
+	vtotal := float64(mode.Vtotal)
+	if mode.ModeFlags&randr.ModeFlagDoubleScan != 0 {
+		vtotal *= 2
+	}
+	if mode.ModeFlags&randr.ModeFlagInterlace != 0 {
+		vtotal /= 2
+	}
+	rate := float64(mode.DotClock) / (float64(mode.Htotal) * vtotal)
+	return math.Round(rate*100) / 100
+}
+
+// queryOutputs lists the active outputs, an output without a CRTC
+// (disconnected or disabled) isn't included
+func queryOutputs(conn *xgb.Conn, root xproto.Window) ([]randrOutput, error) {
+	if err := randr.Init(conn); err != nil {
+		return nil, err
+	}
+	resources, err := randr.GetScreenResourcesCurrent(conn, root).Reply()
+	if err != nil {
+		return nil, err
+	}
+	modes := make(map[randr.Mode]randr.ModeInfo, len(resources.Modes))
+	for _, mode := range resources.Modes {
+		modes[randr.Mode(mode.Id)] = mode
+	}
+	var primary randr.Output
+	if reply, err := randr.GetOutputPrimary(conn, root).Reply(); err == nil {
+		primary = reply.Output
+	}
+
+	var outputs []randrOutput
+	for _, crtc := range resources.Crtcs {
+		info, err := randr.GetCrtcInfo(conn, crtc, resources.ConfigTimestamp).Reply()
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode == 0 || len(info.Outputs) == 0 {
+			continue
+		}
+		output := randrOutput{
+			bounds:      image.Rect(int(info.X), int(info.Y), int(info.X)+int(info.Width), int(info.Y)+int(info.Height)),
+			refreshRate: refreshRate(modes[info.Mode]),
+		}
+		// Cloned outputs share the CRTC, the first one names it
+		for i, id := range info.Outputs {
+			if id == primary {
+				output.primary = true
+			}
+			if i > 0 {
+				continue
+			}
+			outputInfo, err := randr.GetOutputInfo(conn, id, resources.ConfigTimestamp).Reply()
+			if err != nil {
+				return nil, err
+			}
+			output.name = string(outputInfo.Name)
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
+
+// scaleFactor derives the desktop scale factor from the Xft.dpi resource,
+// X11 has no per-monitor scaling so every screen gets the same one
+func scaleFactor(conn *xgb.Conn, root xproto.Window) float64 {
+	reply, err := xproto.GetProperty(conn, false, root, xproto.AtomResourceManager, xproto.AtomString, 0, math.MaxUint32/4).Reply()
+	if err != nil {
+		return 1
+	}
+	for _, line := range strings.Split(string(reply.Value), "\n") {
+		if !strings.HasPrefix(line, "Xft.dpi:") {
+			continue
+		}
+		dpi, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimPrefix(line, "Xft.dpi:")), 64)
+		if err != nil || dpi <= 0 {
+			return 1
+		}
+		return dpi / baseDPI
+	}
+	return 1
+}
+
+// matchOutput finds the output driving a screen, bounds is in root window
+// coordinates. Without an exact match the output that covers most of the
+// screen is taken, panning or a transform can make the CRTC larger
+func matchOutput(bounds image.Rectangle, outputs []randrOutput) (randrOutput, bool) {
+	best, bestArea := -1, 0
+	for i, output := range outputs {
+		if output.bounds == bounds {
+			return output, true
+		}
+		overlap := output.bounds.Intersect(bounds)
+		if area := overlap.Dx() * overlap.Dy(); area > bestArea {
+			best, bestArea = i, area
+		}
+	}
+	if best < 0 {
+		return randrOutput{}, false
+	}
+	return outputs[best], true
+}
+
+// describeScreens fills the screens metadata with the RandR output
+// that drives them
+func describeScreens(screens []Screen) error {
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	root := xproto.Setup(conn).DefaultScreen(conn).Root
+
+	outputs, err := queryOutputs(conn, root)
+	if err != nil {
+		return err
+	}
+	// The CRTCs are positioned in the root window, the screen bounds aren't
+	origin := rootOrigin(conn)
+	scale := scaleFactor(conn, root)
+	for i := range screens {
+		screens[i].Scale = scale
+		output, found := matchOutput(screens[i].Bounds.Add(origin), outputs)
+		if !found {
+			log.Printf("No RandR output for screen %d at %v", screens[i].Index, screens[i].Bounds)
+			continue
+		}
+		screens[i].Name = output.name
+		screens[i].Primary = output.primary
+		screens[i].RefreshRate = output.refreshRate
+	}
+	return nil
+}
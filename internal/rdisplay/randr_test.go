@@ -0,0 +1,30 @@
+package rdisplay
+
+import (
+	"image"
+	"testing"
+)
+
+func TestMatchOutput(t *testing.T) {
+	outputs := []randrOutput{
+		{bounds: image.Rect(0, 0, 1920, 1080), name: "HDMI-1"},
+		{bounds: image.Rect(1920, 0, 3200, 1024), name: "DP-1"},
+		// Panned, the CRTC is larger than the screen
+		{bounds: image.Rect(0, 1080, 2000, 2200), name: "eDP-1"},
+	}
+	tests := []struct {
+		bounds   image.Rectangle
+		expected string
+	}{
+		{image.Rect(0, 0, 1920, 1080), "HDMI-1"},
+		{image.Rect(1920, 0, 3200, 1024), "DP-1"},
+		{image.Rect(0, 1080, 1920, 2160), "eDP-1"},
+		{image.Rect(4000, 0, 5000, 1000), ""},
+	}
+	for _, test := range tests {
+		output, found := matchOutput(test.bounds, outputs)
+		if found != (test.expected != "") || output.name != test.expected {
+			t.Errorf("%v matched %q (%v), expected %q", test.bounds, output.name, found, test.expected)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package rdisplay
 
 import (
 	"image"
+	"log"
 	"time"
 
 	"github.com/kbinani/screenshot"
@@ -36,8 +37,13 @@ func (x *XVideoProvider) Screens() ([]Screen, error) {
 		screens[i] = Screen{
 			Index:  i,
 			Bounds: screenshot.GetDisplayBounds(i),
+			Scale:  1,
 		}
 	}
+	// The metadata is optional, the screens can be captured without it
+	if err := describeScreens(screens); err != nil {
+		log.Printf("Can't get the RandR screen info: %v", err)
+	}
 	return screens, nil
 }
 
@@ -59,9 +65,15 @@ func (g *XScreenGrabber) Start() {
 			default:
 				img, err := screenshot.CaptureRect(g.screen.Bounds)
 				if err != nil {
+					close(g.frames)
+					return
+				}
+				select {
+				case g.frames <- img:
+				case <-g.stop:
+					close(g.frames)
 					return
 				}
-				g.frames <- img
 				ellapsed := time.Now().Sub(startedAt)
 				sleepDuration := delta - ellapsed
 				if sleepDuration > 0 {
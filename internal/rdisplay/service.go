@@ -1,8 +1,12 @@
 package rdisplay
 
-import "image"
+import (
+	"image"
+	"io"
+)
 
-// ScreenGrabber TODO
+// ScreenGrabber TODO, grabbers may reuse the frame buffers so a frame is
+// only valid until the next one is received
 type ScreenGrabber interface {
 	Start()
 	Frames() <-chan *image.RGBA
@@ -13,8 +17,16 @@ type ScreenGrabber interface {
 
 // Screen TODO
 type Screen struct {
-	Index  int
+	Index int
+	// Bounds is the screen area within the virtual desktop
 	Bounds image.Rectangle
+	// Name of the output/monitor, empty when the provider doesn't know it
+	Name    string
+	Primary bool
+	// RefreshRate in Hz, zero when unknown
+	RefreshRate float64
+	// Scale is the desktop scale factor (1 = 96 DPI)
+	Scale float64
 }
 
 // Service TODO
@@ -22,3 +34,52 @@ type Service interface {
 	CreateScreenGrabber(screen Screen, fps int) (ScreenGrabber, error)
 	Screens() ([]Screen, error)
 }
+
+// MouseButton identifies a pointer button, using the DOM MouseEvent.button numbering
+type MouseButton = int
+
+const (
+	//LeftButton main button
+	LeftButton MouseButton = iota
+	//MiddleButton auxiliary button, usually the wheel
+	MiddleButton
+	//RightButton secondary button
+	RightButton
+)
+
+// InputInjector sends synthetic pointer and keyboard events to the display,
+// coordinates are absolute and relative to the virtual desktop (see Screen.Bounds)
+type InputInjector interface {
+	io.Closer
+	MouseMove(x, y int) error
+	MouseButton(button MouseButton, pressed bool) error
+	MouseWheel(dx, dy int) error
+	// Key presses or releases a key, identified by its DOM KeyboardEvent.key value
+	Key(key string, pressed bool) error
+}
+
+// InputService creates input injectors
+type InputService interface {
+	CreateInputInjector() (InputInjector, error)
+}
+
+// Cursor is the pointer image and its absolute position (see Screen.Bounds),
+// Position is where the image Hotspot is
+type Cursor struct {
+	Position image.Point
+	Hotspot  image.Point
+	Image    *image.RGBA
+	// Serial changes whenever the cursor image does
+	Serial uint32
+}
+
+// CursorTracker reads the cursor, screen grabbers don't include it
+type CursorTracker interface {
+	io.Closer
+	Cursor() (*Cursor, error)
+}
+
+// CursorService creates cursor trackers
+type CursorService interface {
+	CreateCursorTracker() (CursorTracker, error)
+}
@@ -0,0 +1,22 @@
+package rdisplay
+
+import (
+	"image"
+
+	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/xinerama"
+)
+
+// rootOrigin returns the position of the first Xinerama screen within the
+// root window. Screen.Bounds are relative to it (that's what the screenshot
+// package measures from) while the X requests use root window coordinates
+func rootOrigin(conn *xgb.Conn) image.Point {
+	if err := xinerama.Init(conn); err != nil {
+		return image.Point{}
+	}
+	reply, err := xinerama.QueryScreens(conn).Reply()
+	if err != nil || len(reply.ScreenInfo) == 0 {
+		return image.Point{}
+	}
+	return image.Point{int(reply.ScreenInfo[0].XOrg), int(reply.ScreenInfo[0].YOrg)}
+}
@@ -0,0 +1,197 @@
+// +build !windows
+
+package rdisplay
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"time"
+
+	"github.com/BurntSushi/xgb"
+	mshm "github.com/BurntSushi/xgb/shm"
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/gen2brain/shm"
+)
+
+// Frames alternate between this many buffers, the grabber writes one
+// while the consumer holds the other
+const xshmBuffers = 2
+
+// XShmVideoProvider captures the X server thru the MIT-SHM extension,
+// everything else is shared with XVideoProvider
+type XShmVideoProvider struct {
+	XVideoProvider
+}
+
+// XShmGrabber captures a screen into a shared memory segment, the frames
+// reuse their buffers so they're only valid until the next one is received
+type XShmGrabber struct {
+	fps    int
+	screen Screen
+	frames chan *image.RGBA
+	stop   chan struct{}
+}
+
+// NewXShmVideoProvider returns a MIT-SHM based video provider
+func NewXShmVideoProvider() (Service, error) {
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err = mshm.Init(conn); err != nil {
+		return nil, fmt.Errorf("MIT-SHM extension not available: %v", err)
+	}
+	return &XShmVideoProvider{}, nil
+}
+
+// CreateScreenGrabber creates a MIT-SHM screen capturer, the X connection
+// and the segment are set up when it starts
+func (*XShmVideoProvider) CreateScreenGrabber(screen Screen, fps int) (ScreenGrabber, error) {
+	if screen.Bounds.Empty() {
+		return nil, fmt.Errorf("Screen %d has no area", screen.Index)
+	}
+	return &XShmGrabber{
+		screen: screen,
+		fps:    fps,
+		frames: make(chan *image.RGBA),
+		stop:   make(chan struct{}),
+	}, nil
+}
+
+// xshmSegment is a shared memory segment attached to the X server
+type xshmSegment struct {
+	conn *xgb.Conn
+	seg  mshm.Seg
+	data []byte
+	// origin of the screen bounds within the root window
+	origin image.Point
+	root   xproto.Window
+}
+
+func newXShmSegment(size int) (*xshmSegment, error) {
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return nil, err
+	}
+	if err = mshm.Init(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s := &xshmSegment{
+		conn:   conn,
+		origin: rootOrigin(conn),
+		root:   xproto.Setup(conn).DefaultScreen(conn).Root,
+	}
+
+	shmID, err := shm.Get(shm.IPC_PRIVATE, size, shm.IPC_CREAT|0600)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// Removed right away, the segment lives until both sides detach it
+	defer shm.Rm(shmID)
+	s.data, err = shm.At(shmID, 0, 0)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s.seg, err = mshm.NewSegId(conn)
+	if err == nil {
+		err = mshm.AttachChecked(conn, s.seg, uint32(shmID), false).Check()
+	}
+	if err != nil {
+		shm.Dt(s.data)
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// capture copies the area of the root window into frame, X sends BGRX
+func (s *xshmSegment) capture(bounds image.Rectangle, frame *image.RGBA) error {
+	area := bounds.Add(s.origin)
+	_, err := mshm.GetImage(s.conn, xproto.Drawable(s.root),
+		int16(area.Min.X), int16(area.Min.Y), uint16(area.Dx()), uint16(area.Dy()),
+		0xffffffff, byte(xproto.ImageFormatZPixmap), s.seg, 0).Reply()
+	if err != nil {
+		return err
+	}
+	pix, data := frame.Pix, s.data[:len(frame.Pix)]
+	for i := 0; i < len(pix); i += 4 {
+		pix[i] = data[i+2]
+		pix[i+1] = data[i+1]
+		pix[i+2] = data[i]
+		pix[i+3] = 255
+	}
+	return nil
+}
+
+func (s *xshmSegment) close() {
+	mshm.Detach(s.conn, s.seg)
+	shm.Dt(s.data)
+	s.conn.Close()
+}
+
+// Frames returns a channel that will receive an image stream
+func (g *XShmGrabber) Frames() <-chan *image.RGBA {
+	return g.frames
+}
+
+// Start initiates the screen capture loop
+func (g *XShmGrabber) Start() {
+	go g.run()
+}
+
+func (g *XShmGrabber) run() {
+	defer close(g.frames)
+	size := g.screen.Bounds.Size()
+	segment, err := newXShmSegment(size.X * size.Y * 4)
+	if err != nil {
+		log.Printf("XShm: can't capture screen %d: %v", g.screen.Index, err)
+		return
+	}
+	defer segment.close()
+
+	var buffers [xshmBuffers]*image.RGBA
+	for i := range buffers {
+		buffers[i] = image.NewRGBA(image.Rectangle{Max: size})
+	}
+	delta := time.Second / time.Duration(g.fps)
+	for i := 0; ; i = (i + 1) % xshmBuffers {
+		startedAt := time.Now()
+		frame := buffers[i]
+		if err := segment.capture(g.screen.Bounds, frame); err != nil {
+			log.Printf("XShm: can't capture screen %d: %v", g.screen.Index, err)
+			return
+		}
+		select {
+		case g.frames <- frame:
+		case <-g.stop:
+			return
+		}
+		if sleepDuration := delta - time.Since(startedAt); sleepDuration > 0 {
+			select {
+			case <-time.After(sleepDuration):
+			case <-g.stop:
+				return
+			}
+		}
+	}
+}
+
+// Stop sends a stop signal to the capture loop
+func (g *XShmGrabber) Stop() {
+	close(g.stop)
+}
+
+// Screen returns a pointer to the screen we're capturing
+func (g *XShmGrabber) Screen() *Screen {
+	return &g.screen
+}
+
+// Fps returns the frames per sec. we're capturing
+func (g *XShmGrabber) Fps() int {
+	return g.fps
+}
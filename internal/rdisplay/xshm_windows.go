@@ -0,0 +1,8 @@
+package rdisplay
+
+import "fmt"
+
+// NewXShmVideoProvider isn't available, Windows has no MIT-SHM
+func NewXShmVideoProvider() (Service, error) {
+	return nil, fmt.Errorf("MIT-SHM capture isn't supported on Windows")
+}
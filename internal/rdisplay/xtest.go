@@ -0,0 +1,232 @@
+package rdisplay
+
+import (
+	"fmt"
+	"image"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgb/xtest"
+)
+
+// X11 core event types, as expected by XTest FakeInput
+const (
+	xKeyPress      = 2
+	xKeyRelease    = 3
+	xButtonPress   = 4
+	xButtonRelease = 5
+	xMotionNotify  = 6
+)
+
+// X11 pointer buttons, 4-7 are the wheel "buttons"
+const (
+	xButtonLeft       = 1
+	xButtonMiddle     = 2
+	xButtonRight      = 3
+	xButtonWheelUp    = 4
+	xButtonWheelDown  = 5
+	xButtonWheelLeft  = 6
+	xButtonWheelRight = 7
+)
+
+// domKeysyms maps the non-printable DOM KeyboardEvent.key values to X keysyms
+var domKeysyms = map[string]xproto.Keysym{
+	"Backspace":   0xff08,
+	"Tab":         0xff09,
+	"Enter":       0xff0d,
+	"Pause":       0xff13,
+	"ScrollLock":  0xff14,
+	"Escape":      0xff1b,
+	"Home":        0xff50,
+	"ArrowLeft":   0xff51,
+	"ArrowUp":     0xff52,
+	"ArrowRight":  0xff53,
+	"ArrowDown":   0xff54,
+	"PageUp":      0xff55,
+	"PageDown":    0xff56,
+	"End":         0xff57,
+	"PrintScreen": 0xff61,
+	"Insert":      0xff63,
+	"ContextMenu": 0xff67,
+	"NumLock":     0xff7f,
+	"F1":          0xffbe,
+	"F2":          0xffbf,
+	"F3":          0xffc0,
+	"F4":          0xffc1,
+	"F5":          0xffc2,
+	"F6":          0xffc3,
+	"F7":          0xffc4,
+	"F8":          0xffc5,
+	"F9":          0xffc6,
+	"F10":         0xffc7,
+	"F11":         0xffc8,
+	"F12":         0xffc9,
+	"Shift":       0xffe1,
+	"Control":     0xffe3,
+	"CapsLock":    0xffe5,
+	"Meta":        0xffe7,
+	"Alt":         0xffe9,
+	"AltGraph":    0xfe03,
+	"OS":          0xffeb,
+	"Super":       0xffeb,
+	"Delete":      0xffff,
+}
+
+// XInputInjector injects input events into the X server thru the XTest extension
+type XInputInjector struct {
+	mutex    sync.Mutex
+	conn     *xgb.Conn
+	root     xproto.Window
+	origin   image.Point
+	keycodes map[xproto.Keysym]xproto.Keycode
+}
+
+// CreateInputInjector connects to the X server and checks XTest is available
+func (*XVideoProvider) CreateInputInjector() (InputInjector, error) {
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return nil, err
+	}
+	if err = xtest.Init(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("XTest extension not available: %v", err)
+	}
+	setup := xproto.Setup(conn)
+	keycodes, err := loadKeyboardMapping(conn, setup)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &XInputInjector{
+		conn:     conn,
+		root:     setup.DefaultScreen(conn).Root,
+		origin:   rootOrigin(conn),
+		keycodes: keycodes,
+	}, nil
+}
+
+// loadKeyboardMapping builds a keysym -> keycode lookup table, when a keysym is
+// bound to more than one keycode the lowest one wins
+func loadKeyboardMapping(conn *xgb.Conn, setup *xproto.SetupInfo) (map[xproto.Keysym]xproto.Keycode, error) {
+	count := byte(setup.MaxKeycode - setup.MinKeycode + 1)
+	mapping, err := xproto.GetKeyboardMapping(conn, setup.MinKeycode, count).Reply()
+	if err != nil {
+		return nil, err
+	}
+	perKeycode := int(mapping.KeysymsPerKeycode)
+	keycodes := make(map[xproto.Keysym]xproto.Keycode, len(mapping.Keysyms))
+	for i, keysym := range mapping.Keysyms {
+		if keysym == 0 {
+			continue
+		}
+		if _, exists := keycodes[keysym]; !exists {
+			keycodes[keysym] = setup.MinKeycode + xproto.Keycode(i/perKeycode)
+		}
+	}
+	return keycodes, nil
+}
+
+func (x *XInputInjector) fakeInput(eventType byte, detail byte, rootX, rootY int16) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	return xtest.FakeInputChecked(x.conn, eventType, detail, 0, x.root, rootX, rootY, 0).Check()
+}
+
+// MouseMove moves the pointer to the absolute position (x, y), XTest
+// expects root window coordinates
+func (x *XInputInjector) MouseMove(posX, posY int) error {
+	return x.fakeInput(xMotionNotify, 0, int16(posX+x.origin.X), int16(posY+x.origin.Y))
+}
+
+// MouseButton presses or releases a pointer button
+func (x *XInputInjector) MouseButton(button MouseButton, pressed bool) error {
+	var xButton byte
+	switch button {
+	case LeftButton:
+		xButton = xButtonLeft
+	case MiddleButton:
+		xButton = xButtonMiddle
+	case RightButton:
+		xButton = xButtonRight
+	default:
+		return fmt.Errorf("Unsupported mouse button %d", button)
+	}
+	eventType := byte(xButtonRelease)
+	if pressed {
+		eventType = xButtonPress
+	}
+	return x.fakeInput(eventType, xButton, 0, 0)
+}
+
+func (x *XInputInjector) clickButton(button byte, times int) error {
+	for i := 0; i < times; i++ {
+		if err := x.fakeInput(xButtonPress, button, 0, 0); err != nil {
+			return err
+		}
+		if err := x.fakeInput(xButtonRelease, button, 0, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MouseWheel scrolls dx/dy steps, X has no notion of wheel deltas so each step
+// is a click of the corresponding wheel button
+func (x *XInputInjector) MouseWheel(dx, dy int) error {
+	var err error
+	if dy > 0 {
+		err = x.clickButton(xButtonWheelDown, dy)
+	} else if dy < 0 {
+		err = x.clickButton(xButtonWheelUp, -dy)
+	}
+	if err != nil {
+		return err
+	}
+	if dx > 0 {
+		err = x.clickButton(xButtonWheelRight, dx)
+	} else if dx < 0 {
+		err = x.clickButton(xButtonWheelLeft, -dx)
+	}
+	return err
+}
+
+// keysymForKey translates a DOM KeyboardEvent.key value into a X keysym
+func keysymForKey(key string) (xproto.Keysym, error) {
+	if keysym, found := domKeysyms[key]; found {
+		return keysym, nil
+	}
+	if utf8.RuneCountInString(key) != 1 {
+		return 0, fmt.Errorf("Unsupported key %q", key)
+	}
+	r, _ := utf8.DecodeRuneInString(key)
+	if r < 0x100 {
+		// Latin-1 keysyms match their code points
+		return xproto.Keysym(r), nil
+	}
+	return xproto.Keysym(0x01000000 | r), nil
+}
+
+// Key presses or releases the key identified by its DOM KeyboardEvent.key value
+func (x *XInputInjector) Key(key string, pressed bool) error {
+	keysym, err := keysymForKey(key)
+	if err != nil {
+		return err
+	}
+	keycode, found := x.keycodes[keysym]
+	if !found {
+		return fmt.Errorf("No keycode for key %q (keysym 0x%x)", key, keysym)
+	}
+	eventType := byte(xKeyRelease)
+	if pressed {
+		eventType = xKeyPress
+	}
+	return x.fakeInput(eventType, byte(keycode), 0, 0)
+}
+
+// Close closes the X server connection
+func (x *XInputInjector) Close() error {
+	x.conn.Close()
+	return nil
+}
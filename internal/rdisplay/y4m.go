@@ -0,0 +1,194 @@
+package rdisplay
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Y4MProvider plays a YUV4MPEG2 file (4:2:0) in a loop, at the frame rate
+// of the file
+type Y4MProvider struct {
+	path   string
+	header y4mHeader
+	screen Screen
+}
+
+// y4mHeader is the stream header of a Y4M file, frames start at offset
+type y4mHeader struct {
+	size   image.Point
+	fps    float64
+	offset int64
+}
+
+// NewY4MProvider opens a Y4M file, only 4:2:0 chroma is supported
+func NewY4MProvider(path string) (Service, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	header, err := readY4MHeader(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &Y4MProvider{
+		path:   path,
+		header: header,
+		screen: singleScreen(filepath.Base(path), header.size, header.fps),
+	}, nil
+}
+
+func readY4MHeader(reader *bufio.Reader) (y4mHeader, error) {
+	header := y4mHeader{fps: 25}
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return header, err
+	}
+	header.offset = int64(len(line))
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != "YUV4MPEG2" {
+		return header, fmt.Errorf("Not a Y4M file")
+	}
+	for _, field := range fields[1:] {
+		value := field[1:]
+		switch field[0] {
+		case 'W':
+			header.size.X, err = strconv.Atoi(value)
+		case 'H':
+			header.size.Y, err = strconv.Atoi(value)
+		case 'F':
+			parts := strings.SplitN(value, ":", 2)
+			num, numErr := strconv.Atoi(parts[0])
+			den := 1
+			if len(parts) == 2 {
+				den, err = strconv.Atoi(parts[1])
+			}
+			if numErr != nil || err != nil || num <= 0 || den <= 0 {
+				return header, fmt.Errorf("Invalid frame rate %s", value)
+			}
+			header.fps = float64(num) / float64(den)
+		case 'I':
+			if value != "p" && value != "?" {
+				return header, fmt.Errorf("Interlaced video isn't supported")
+			}
+		case 'C':
+			if !strings.HasPrefix(value, "420") {
+				return header, fmt.Errorf("Unsupported chroma subsampling %s, only 4:2:0 is supported", value)
+			}
+		}
+		if err != nil {
+			return header, fmt.Errorf("Invalid header field %s", field)
+		}
+	}
+	if header.size.X < 2 || header.size.Y < 2 {
+		return header, fmt.Errorf("Invalid frame size %dx%d", header.size.X, header.size.Y)
+	}
+	return header, nil
+}
+
+// Screens returns the screen of the file
+func (p *Y4MProvider) Screens() ([]Screen, error) {
+	return []Screen{p.screen}, nil
+}
+
+// y4mReader reads the frames of a file, going back to the first one at the end
+type y4mReader struct {
+	file   *os.File
+	reader *bufio.Reader
+	header y4mHeader
+	frame  *image.YCbCr
+	index  int
+}
+
+// next reads the next frame
+func (r *y4mReader) next() error {
+	for attempt := 0; attempt < 2; attempt++ {
+		line, err := r.reader.ReadString('\n')
+		if err == io.EOF && attempt == 0 && r.index > 0 {
+			if _, err = r.file.Seek(r.header.offset, io.SeekStart); err != nil {
+				return err
+			}
+			r.reader.Reset(r.file)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "FRAME") {
+			return fmt.Errorf("Invalid frame header")
+		}
+		for _, plane := range [][]byte{r.frame.Y, r.frame.Cb, r.frame.Cr} {
+			if _, err := io.ReadFull(r.reader, plane); err != nil {
+				return err
+			}
+		}
+		r.index++
+		return nil
+	}
+	return fmt.Errorf("The file has no frames")
+}
+
+// open opens the file at its first frame
+func (p *Y4MProvider) open() (*y4mReader, error) {
+	file, err := os.Open(p.path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = file.Seek(p.header.offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &y4mReader{
+		file:   file,
+		reader: bufio.NewReader(file),
+		header: p.header,
+		frame:  image.NewYCbCr(image.Rectangle{Max: p.header.size}, image.YCbCrSubsampleRatio420),
+	}, nil
+}
+
+// CreateScreenGrabber creates a grabber that plays the file cropped to the
+// screen bounds, frames are skipped or repeated to keep the file frame rate.
+// The file is opened when the grabber starts
+func (p *Y4MProvider) CreateScreenGrabber(screen Screen, fps int) (ScreenGrabber, error) {
+	area, err := sourceArea(p.screen, screen)
+	if err != nil {
+		return nil, err
+	}
+	var reader *y4mReader
+	played := 0
+	grabber := newPacedGrabber(screen, fps, func(elapsed time.Duration) *image.RGBA {
+		var err error
+		if reader == nil {
+			if reader, err = p.open(); err != nil {
+				log.Printf("Y4M: %v", err)
+				return nil
+			}
+		}
+		target := int(elapsed.Seconds() * p.header.fps)
+		for played == 0 || played <= target {
+			if err = reader.next(); err != nil {
+				log.Printf("Y4M: %s: %v", p.path, err)
+				return nil
+			}
+			played++
+		}
+		frame := image.NewRGBA(image.Rectangle{Max: area.Size()})
+		draw.Draw(frame, frame.Rect, reader.frame, area.Min, draw.Src)
+		return frame
+	})
+	grabber.release = func() {
+		if reader != nil {
+			reader.file.Close()
+		}
+	}
+	return grabber, nil
+}
@@ -0,0 +1,84 @@
+package rdisplay
+
+import (
+	"bufio"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReadY4MHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		size   image.Point
+		fps    float64
+		err    string
+	}{
+		{"YUV4MPEG2 W640 H360 F30:1 Ip A1:1 C420jpeg\n", image.Point{640, 360}, 30, ""},
+		{"YUV4MPEG2 W320 H240 F30000:1001 C420mpeg2 XYSCSS=420MPEG2\n", image.Point{320, 240}, 30000.0 / 1001, ""},
+		{"YUV4MPEG2 W320 H240 F24\n", image.Point{320, 240}, 24, ""},
+		// The frame rate defaults to 25
+		{"YUV4MPEG2 W320 H240\n", image.Point{320, 240}, 25, ""},
+		{"YUV4MPEG2 W320 H240 Ib\n", image.Point{}, 0, "Interlaced"},
+		{"YUV4MPEG2 W320 H240 C444\n", image.Point{}, 0, "Unsupported chroma"},
+		{"YUV4MPEG2 W320 H240 F30:0\n", image.Point{}, 0, "Invalid frame rate"},
+		{"YUV4MPEG2 Wabc H240\n", image.Point{}, 0, "Invalid header field"},
+		{"YUV4MPEG2 H240\n", image.Point{}, 0, "Invalid frame size"},
+		{"RIFF W320 H240\n", image.Point{}, 0, "Not a Y4M file"},
+		{"YUV4MPEG2 W320 H240", image.Point{}, 0, "EOF"},
+	}
+	for _, test := range tests {
+		header, err := readY4MHeader(bufio.NewReader(strings.NewReader(test.header)))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: got error %v, expected %q", test.header, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.header, err)
+			continue
+		}
+		if header.size != test.size || header.fps != test.fps || header.offset != int64(len(test.header)) {
+			t.Errorf("%q: got %+v", test.header, header)
+		}
+	}
+}
+
+func TestY4MCrop(t *testing.T) {
+	file, err := ioutil.TempFile("", "video.y4m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	// A frame with a different luma on every pixel, neutral chroma
+	size := image.Point{64, 48}
+	frame := image.NewYCbCr(image.Rectangle{Max: size}, image.YCbCrSubsampleRatio420)
+	for i := range frame.Y {
+		frame.Y[i] = byte(16 + i%200)
+	}
+	for i := range frame.Cb {
+		frame.Cb[i], frame.Cr[i] = 128, 128
+	}
+	writer := bufio.NewWriter(file)
+	writer.WriteString("YUV4MPEG2 W64 H48 F10:1 Ip C420jpeg\nFRAME\n")
+	for _, plane := range [][]byte{frame.Y, frame.Cb, frame.Cr} {
+		writer.Write(plane)
+	}
+	writer.Flush()
+	file.Close()
+
+	service, err := NewY4MProvider(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCrop(t, service)
+	screens, _ := service.Screens()
+	full := firstFrame(t, service, screens[0])
+	if got, expected := full.RGBAAt(5, 3), color.RGBAModel.Convert(frame.At(5, 3)); got != expected {
+		t.Errorf("Pixel (5, 3) is %v, expected %v", got, expected)
+	}
+}
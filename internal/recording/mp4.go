@@ -0,0 +1,267 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	"os"
+	"time"
+)
+
+// Timescale of the video track, the usual 90 kHz clock
+const mp4Timescale = 90000
+
+// Duration of the last sample when there's only one
+const mp4DefaultDuration = mp4Timescale / 30
+
+// H.264 NAL unit types, the parameter sets don't go into the samples,
+// they're stored once in the sample description (avcC)
+const (
+	nalTypeIDR = 5
+	nalTypeSPS = 7
+	nalTypePPS = 8
+	nalTypeAUD = 9
+)
+
+type mp4Sample struct {
+	offset    uint64
+	size      uint32
+	timestamp uint64
+	sync      bool
+}
+
+// mp4Writer writes H.264 frames to an MP4 file, the samples go into the mdat
+// box as they come and the index (moov box) is appended on Close
+type mp4Writer struct {
+	file    *os.File
+	buffer  *bufio.Writer
+	size    image.Point
+	offset  uint64
+	sps     []byte
+	pps     []byte
+	samples []mp4Sample
+}
+
+// File type box, the mdat box comes right after it
+var mp4FileType = mp4Box("ftyp", []byte("isom"), u32(512), []byte("isomiso2avc1mp41"))
+
+func newMP4Writer(file *os.File, size image.Point) (Writer, error) {
+	buffer := bufio.NewWriter(file)
+	// The mdat size is filled in on Close, the 64 bit variant
+	// doesn't limit the length of the recording
+	mdat := make([]byte, 16)
+	binary.BigEndian.PutUint32(mdat[0:], 1)
+	copy(mdat[4:], "mdat")
+	for _, header := range [][]byte{mp4FileType, mdat} {
+		if _, err := buffer.Write(header); err != nil {
+			return nil, err
+		}
+	}
+	return &mp4Writer{
+		file:   file,
+		buffer: buffer,
+		size:   size,
+		offset: uint64(len(mp4FileType) + len(mdat)),
+	}, nil
+}
+
+// WriteFrame converts the Annex-B frame into a sample of length prefixed NAL units
+func (w *mp4Writer) WriteFrame(frame []byte, timestamp time.Duration) error {
+	sample := mp4Sample{
+		offset:    w.offset,
+		timestamp: uint64(timestamp) * mp4Timescale / uint64(time.Second),
+	}
+	for _, nal := range splitAnnexB(frame) {
+		switch nal[0] & 0x1f {
+		case nalTypeSPS:
+			// The profile and level are copied from it into avcC
+			if w.sps == nil && len(nal) >= 4 {
+				w.sps = append([]byte(nil), nal...)
+			}
+			continue
+		case nalTypePPS:
+			if w.pps == nil {
+				w.pps = append([]byte(nil), nal...)
+			}
+			continue
+		case nalTypeAUD:
+			continue
+		case nalTypeIDR:
+			sample.sync = true
+		}
+		if _, err := w.buffer.Write(u32(uint32(len(nal)))); err != nil {
+			return err
+		}
+		if _, err := w.buffer.Write(nal); err != nil {
+			return err
+		}
+		sample.size += 4 + uint32(len(nal))
+	}
+	if sample.size == 0 {
+		return nil
+	}
+	w.offset += uint64(sample.size)
+	w.samples = append(w.samples, sample)
+	return nil
+}
+
+func (w *mp4Writer) Close() error {
+	err := w.buffer.Flush()
+	if err == nil {
+		mdatSize := w.offset - uint64(len(mp4FileType))
+		_, err = w.file.WriteAt(u64(mdatSize), int64(len(mp4FileType))+8)
+	}
+	// Without parameter sets there's nothing that can be decoded
+	if err == nil && w.sps != nil && w.pps != nil && len(w.samples) > 0 {
+		_, err = w.file.WriteAt(w.movie(), int64(w.offset))
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// durations returns how long each sample is shown, in mp4Timescale units
+func (w *mp4Writer) durations() []uint32 {
+	durations := make([]uint32, len(w.samples))
+	for i := 0; i+1 < len(w.samples); i++ {
+		durations[i] = uint32(w.samples[i+1].timestamp - w.samples[i].timestamp)
+	}
+	last := uint32(mp4DefaultDuration)
+	if len(w.samples) > 1 {
+		last = durations[len(w.samples)-2]
+	}
+	durations[len(w.samples)-1] = last
+	return durations
+}
+
+// movie builds the moov box, a single video track with a sample per chunk
+func (w *mp4Writer) movie() []byte {
+	durations := w.durations()
+	var total uint64
+	for _, duration := range durations {
+		total += uint64(duration)
+	}
+	// The movie header uses milliseconds
+	movieDuration := u32(uint32(total * 1000 / mp4Timescale))
+
+	stts := []byte{}
+	entries := 0
+	for i := 0; i < len(durations); {
+		run := 1
+		for i+run < len(durations) && durations[i+run] == durations[i] {
+			run++
+		}
+		stts = append(stts, u32(uint32(run))...)
+		stts = append(stts, u32(durations[i])...)
+		entries++
+		i += run
+	}
+	stss, stsz, co64 := []byte{}, []byte{}, []byte{}
+	syncCount := 0
+	for i, sample := range w.samples {
+		if sample.sync {
+			stss = append(stss, u32(uint32(i+1))...)
+			syncCount++
+		}
+		stsz = append(stsz, u32(sample.size)...)
+		co64 = append(co64, u64(sample.offset)...)
+	}
+	count := u32(uint32(len(w.samples)))
+
+	sampleTable := mp4Box("stbl",
+		fullBox("stsd", 0, 0, u32(1), w.sampleEntry()),
+		fullBox("stts", 0, 0, u32(uint32(entries)), stts),
+		fullBox("stss", 0, 0, u32(uint32(syncCount)), stss),
+		fullBox("stsc", 0, 0, u32(1), u32(1), u32(1), u32(1)),
+		fullBox("stsz", 0, 0, u32(0), count, stsz),
+		fullBox("co64", 0, 0, count, co64),
+	)
+	mediaInfo := mp4Box("minf",
+		fullBox("vmhd", 0, 1, make([]byte, 8)),
+		mp4Box("dinf", fullBox("dref", 0, 0, u32(1), fullBox("url ", 0, 1))),
+		sampleTable,
+	)
+	media := mp4Box("mdia",
+		// Language "und", packed ISO-639-2/T
+		fullBox("mdhd", 0, 0, u32(0), u32(0), u32(mp4Timescale), u32(uint32(total)), u16(0x55c4), u16(0)),
+		fullBox("hdlr", 0, 0, u32(0), []byte("vide"), make([]byte, 12), []byte("VideoHandler\x00")),
+		mediaInfo,
+	)
+	track := mp4Box("trak",
+		// Flags: track enabled and in the movie
+		fullBox("tkhd", 0, 3, u32(0), u32(0), u32(1), u32(0), movieDuration, make([]byte, 8),
+			u16(0), u16(0), u16(0), u16(0), mp4Matrix(),
+			u32(uint32(w.size.X)<<16), u32(uint32(w.size.Y)<<16)),
+		media,
+	)
+	return mp4Box("moov",
+		fullBox("mvhd", 0, 0, u32(0), u32(0), u32(1000), movieDuration,
+			u32(0x00010000), u16(0x0100), make([]byte, 10), mp4Matrix(), make([]byte, 24), u32(2)),
+		track,
+	)
+}
+
+// sampleEntry describes the H.264 stream (avc1 with its avcC configuration)
+func (w *mp4Writer) sampleEntry() []byte {
+	config := []byte{1, w.sps[1], w.sps[2], w.sps[3], 0xff, 0xe1}
+	config = append(config, u16(uint16(len(w.sps)))...)
+	config = append(config, w.sps...)
+	config = append(config, 1)
+	config = append(config, u16(uint16(len(w.pps)))...)
+	config = append(config, w.pps...)
+	return mp4Box("avc1",
+		make([]byte, 6), u16(1), make([]byte, 16),
+		u16(uint16(w.size.X)), u16(uint16(w.size.Y)),
+		// 72 DPI
+		u32(0x00480000), u32(0x00480000), u32(0), u16(1),
+		make([]byte, 32), u16(0x0018), u16(0xffff),
+		mp4Box("avcC", config),
+	)
+}
+
+// mp4Matrix is the identity transformation matrix
+func mp4Matrix() []byte {
+	matrix := make([]byte, 0, 36)
+	for _, value := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		matrix = append(matrix, u32(value)...)
+	}
+	return matrix
+}
+
+func mp4Box(boxType string, content ...[]byte) []byte {
+	size := 8
+	for _, c := range content {
+		size += len(c)
+	}
+	box := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(box, uint32(size))
+	copy(box[4:], boxType)
+	for _, c := range content {
+		box = append(box, c...)
+	}
+	return box
+}
+
+func fullBox(boxType string, version byte, flags uint32, content ...[]byte) []byte {
+	header := u32(uint32(version)<<24 | flags)
+	return mp4Box(boxType, append([][]byte{header}, content...)...)
+}
+
+func u16(value uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, value)
+	return b
+}
+
+func u32(value uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, value)
+	return b
+}
+
+func u64(value uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, value)
+	return b
+}
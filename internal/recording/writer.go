@@ -0,0 +1,196 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rviscarra/webrtc-remote-screen/internal/encoders"
+)
+
+// Writer stores encoded frames in a container file
+type Writer interface {
+	io.Closer
+	// WriteFrame appends a frame, timestamp is relative to the start of the recording
+	WriteFrame(frame []byte, timestamp time.Duration) error
+}
+
+// IVF timebase, frame timestamps are stored in milliseconds
+const ivfTimebase = 1000
+
+// ivfWriter writes VP8, VP9 or AV1 frames to an IVF file, the frame count in the
+// header is filled in on Close
+type ivfWriter struct {
+	file   *os.File
+	buffer *bufio.Writer
+	frames uint32
+}
+
+func newIVFWriter(file *os.File, fourcc string, size image.Point) (Writer, error) {
+	header := make([]byte, 32)
+	copy(header[0:], "DKIF")
+	binary.LittleEndian.PutUint16(header[4:], 0)  // version
+	binary.LittleEndian.PutUint16(header[6:], 32) // header size
+	copy(header[8:], fourcc)
+	binary.LittleEndian.PutUint16(header[12:], uint16(size.X))
+	binary.LittleEndian.PutUint16(header[14:], uint16(size.Y))
+	binary.LittleEndian.PutUint32(header[16:], ivfTimebase)
+	binary.LittleEndian.PutUint32(header[20:], 1)
+	buffer := bufio.NewWriter(file)
+	if _, err := buffer.Write(header); err != nil {
+		return nil, err
+	}
+	return &ivfWriter{
+		file:   file,
+		buffer: buffer,
+	}, nil
+}
+
+func (w *ivfWriter) WriteFrame(frame []byte, timestamp time.Duration) error {
+	header := make([]byte, 12)
+	binary.LittleEndian.PutUint32(header[0:], uint32(len(frame)))
+	binary.LittleEndian.PutUint64(header[4:], uint64(timestamp/time.Millisecond))
+	if _, err := w.buffer.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.buffer.Write(frame); err != nil {
+		return err
+	}
+	w.frames++
+	return nil
+}
+
+func (w *ivfWriter) Close() error {
+	err := w.buffer.Flush()
+	if err == nil {
+		count := make([]byte, 4)
+		binary.LittleEndian.PutUint32(count, w.frames)
+		_, err = w.file.WriteAt(count, 24)
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Create creates a recording file for the codec in dir, named after name,
+// and returns its path
+func Create(dir, name string, codec encoders.VideoCodec, size image.Point) (Writer, string, error) {
+	var extension string
+	switch codec {
+	case encoders.VP8Codec, encoders.VP9Codec, encoders.AV1Codec:
+		extension = ".ivf"
+	case encoders.H264Codec:
+		extension = ".mp4"
+	default:
+		return nil, "", fmt.Errorf("Recording not supported for codec %d", codec)
+	}
+	path := filepath.Join(dir, name+extension)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, "", err
+	}
+	var writer Writer
+	switch codec {
+	case encoders.H264Codec:
+		writer, err = newMP4Writer(file, size)
+	case encoders.VP9Codec:
+		writer, err = newIVFWriter(file, "VP90", size)
+	case encoders.AV1Codec:
+		writer, err = newIVFWriter(file, "AV01", size)
+	default:
+		writer, err = newIVFWriter(file, "VP80", size)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, "", err
+	}
+	return writer, path, nil
+}
+
+// IsKeyFrame checks if an encoded frame can be decoded on its own,
+// recordings must start with one
+func IsKeyFrame(codec encoders.VideoCodec, frame []byte) bool {
+	switch codec {
+	case encoders.VP8Codec:
+		// Inverse key frame flag in the first bit of the frame tag (RFC 6386, 9.1)
+		return len(frame) > 0 && frame[0]&0x01 == 0
+	case encoders.VP9Codec:
+		return isVP9KeyFrame(frame)
+	case encoders.AV1Codec:
+		// The encoder repeats the sequence header on every keyframe
+		obus, err := encoders.SplitOBUs(frame)
+		if err != nil {
+			return false
+		}
+		for _, obu := range obus {
+			if obu.Type == encoders.OBUSequenceHeader {
+				return true
+			}
+		}
+	case encoders.H264Codec:
+		for _, nalType := range annexBNALTypes(frame) {
+			if nalType == 5 || nalType == 7 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isVP9KeyFrame reads the frame type of the uncompressed header (VP9
+// bitstream spec, 6.2), the profile decides where it is
+func isVP9KeyFrame(frame []byte) bool {
+	if len(frame) == 0 || frame[0]>>6 != 2 {
+		return false
+	}
+	profile := frame[0]>>5&1 | frame[0]>>3&2
+	shift := uint(3)
+	if profile == 3 {
+		shift--
+	}
+	showExisting := frame[0] >> shift & 1
+	frameType := frame[0] >> (shift - 1) & 1
+	return showExisting == 0 && frameType == 0
+}
+
+// annexBNALTypes returns the type of each NAL unit of an Annex-B stream
+func annexBNALTypes(stream []byte) []byte {
+	var types []byte
+	for _, nal := range splitAnnexB(stream) {
+		types = append(types, nal[0]&0x1f)
+	}
+	return types
+}
+
+// splitAnnexB returns the NAL units of an Annex-B stream, without their start codes
+func splitAnnexB(stream []byte) [][]byte {
+	var nals [][]byte
+	start := -1
+	appendNAL := func(end int) {
+		if start >= 0 && end > start {
+			nals = append(nals, stream[start:end])
+		}
+	}
+	for i := 0; i+2 < len(stream); i++ {
+		if stream[i] != 0 || stream[i+1] != 0 || stream[i+2] != 1 {
+			continue
+		}
+		end := i
+		// The leading zero of a 4 byte start code belongs to it
+		if end > 0 && stream[end-1] == 0 {
+			end--
+		}
+		appendNAL(end)
+		start = i + 3
+		i += 2
+	}
+	appendNAL(len(stream))
+	return nals
+}
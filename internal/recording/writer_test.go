@@ -0,0 +1,200 @@
+package recording
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rviscarra/webrtc-remote-screen/internal/encoders"
+)
+
+// H.264 access units as the encoder sends them, with their parameter sets
+var (
+	testSPS   = []byte{0x67, 0x42, 0xc0, 0x1f, 0xda, 0x01}
+	testPPS   = []byte{0x68, 0xce, 0x3c, 0x80}
+	testIDR   = []byte{0x65, 0x88, 0x84, 0x21}
+	testSlice = []byte{0x41, 0x9a, 0x02}
+)
+
+func annexB(nals ...[]byte) []byte {
+	stream := []byte{}
+	for i, nal := range nals {
+		if i == 0 {
+			stream = append(stream, 0, 0, 0, 1)
+		} else {
+			stream = append(stream, 0, 0, 1)
+		}
+		stream = append(stream, nal...)
+	}
+	return stream
+}
+
+func TestSplitAnnexB(t *testing.T) {
+	nals := splitAnnexB(annexB(testSPS, testPPS, testIDR))
+	if !reflect.DeepEqual(nals, [][]byte{testSPS, testPPS, testIDR}) {
+		t.Errorf("Unexpected NAL units %x", nals)
+	}
+	if nals := splitAnnexB([]byte{0, 0, 1}); len(nals) != 0 {
+		t.Errorf("An empty stream has NAL units %x", nals)
+	}
+	if !IsKeyFrame(encoders.H264Codec, annexB(testSPS, testPPS, testIDR)) {
+		t.Error("An IDR access unit isn't a keyframe")
+	}
+	if IsKeyFrame(encoders.H264Codec, annexB(testSlice)) {
+		t.Error("A P slice is a keyframe")
+	}
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// mp4Boxes indexes the boxes of an MP4 file by their path, e.g. "moov/trak"
+func mp4Boxes(t *testing.T, data []byte, prefix string, boxes map[string][]byte) {
+	t.Helper()
+	containers := map[string]bool{"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true}
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		boxType := string(data[4:8])
+		header := uint64(8)
+		if size == 1 {
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			t.Fatalf("Box %s%s has an invalid size %d", prefix, boxType, size)
+		}
+		boxes[prefix+boxType] = data[header:size]
+		if containers[boxType] {
+			mp4Boxes(t, data[header:size], prefix+boxType+"/", boxes)
+		}
+		data = data[size:]
+	}
+	if len(data) > 0 {
+		t.Fatalf("%d trailing bytes after the boxes of %q", len(data), prefix)
+	}
+}
+
+// mp4Table reads the uint32 entries of a full box that follow its entry count
+func mp4Table(box []byte) []uint32 {
+	var entries []uint32
+	for i := 8; i+4 <= len(box); i += 4 {
+		entries = append(entries, binary.BigEndian.Uint32(box[i:]))
+	}
+	return entries
+}
+
+func TestMP4Writer(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writer, path, err := Create(dir, "session", encoders.H264Codec, image.Point{1280, 720})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := []struct {
+		data      []byte
+		timestamp time.Duration
+	}{
+		{annexB(testSPS, testPPS, testIDR), 0},
+		{annexB(testSlice), 100 * time.Millisecond},
+		// The screen went idle for a second
+		{annexB(testSlice), 1100 * time.Millisecond},
+		{annexB(testSPS, testPPS, testIDR), 1200 * time.Millisecond},
+	}
+	for _, frame := range frames {
+		if err := writer.WriteFrame(frame.data, frame.timestamp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boxes := map[string][]byte{}
+	mp4Boxes(t, data, "", boxes)
+
+	stbl := "moov/trak/mdia/minf/stbl/"
+	stts := mp4Table(boxes[stbl+"stts"])
+	expected := []uint32{1, 9000, 1, 90000, 2, 9000}
+	if !reflect.DeepEqual(stts, expected) {
+		t.Errorf("Got sample durations %v, expected %v", stts, expected)
+	}
+	if stss := mp4Table(boxes[stbl+"stss"]); !reflect.DeepEqual(stss, []uint32{1, 4}) {
+		t.Errorf("Got sync samples %v, expected [1 4]", stss)
+	}
+	stsz := mp4Table(boxes[stbl+"stsz"])
+	idrSize, sliceSize := uint32(4+len(testIDR)), uint32(4+len(testSlice))
+	if !reflect.DeepEqual(stsz, []uint32{4, idrSize, sliceSize, sliceSize, idrSize}) {
+		t.Errorf("Got sample sizes %v", stsz)
+	}
+	mdhd := boxes["moov/trak/mdia/mdhd"]
+	if duration := binary.BigEndian.Uint32(mdhd[16:]); duration != 117000 {
+		t.Errorf("The track lasts %d, expected 117000", duration)
+	}
+
+	// The samples are stored length prefixed, without the parameter sets
+	mdat := boxes["mdat"]
+	sample := append([]byte{0, 0, 0, byte(len(testIDR))}, testIDR...)
+	if !bytes.HasPrefix(mdat, sample) {
+		t.Errorf("The first sample is %x, expected %x", mdat[:len(sample)], sample)
+	}
+	co64 := boxes[stbl+"co64"]
+	if offset := binary.BigEndian.Uint64(co64[8:]); !bytes.Equal(data[offset:int(offset)+len(sample)], sample) {
+		t.Errorf("The first chunk offset %d doesn't point to the first sample", offset)
+	}
+	stsd := boxes[stbl+"stsd"]
+	avcC := bytes.Index(stsd, []byte("avcC"))
+	if avcC < 0 {
+		t.Fatal("No avcC box in the sample description")
+	}
+	config := append([]byte{1, 0x42, 0xc0, 0x1f, 0xff, 0xe1, 0, byte(len(testSPS))}, testSPS...)
+	config = append(append(config, 1, 0, byte(len(testPPS))), testPPS...)
+	if got := stsd[avcC+4:]; !bytes.Equal(got, config) {
+		t.Errorf("Got the decoder configuration %x, expected %x", got, config)
+	}
+}
+
+func TestIVFWriter(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writer, path, err := Create(dir, "session", encoders.VP8Codec, image.Point{640, 360})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.WriteFrame([]byte{0x10, 0x02}, 0)
+	writer.WriteFrame([]byte{0x11, 0x02, 0x03}, 1500*time.Millisecond)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[:4]) != "DKIF" || string(data[8:12]) != "VP80" {
+		t.Fatalf("Invalid IVF header %x", data[:32])
+	}
+	size := image.Point{int(binary.LittleEndian.Uint16(data[12:])), int(binary.LittleEndian.Uint16(data[14:]))}
+	if size != (image.Point{640, 360}) {
+		t.Errorf("The header size is %v", size)
+	}
+	if count := binary.LittleEndian.Uint32(data[24:]); count != 2 {
+		t.Errorf("The header counts %d frames, expected 2", count)
+	}
+	second := data[32+12+2:]
+	if timestamp := binary.LittleEndian.Uint64(second[4:]); timestamp != 1500 {
+		t.Errorf("The second frame timestamp is %d, expected 1500", timestamp)
+	}
+}
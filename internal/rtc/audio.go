@@ -0,0 +1,142 @@
+package rtc
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pion/sdp"
+	"github.com/pion/webrtc/v2"
+	"github.com/pion/webrtc/v2/pkg/media"
+	"github.com/rviscarra/webrtc-remote-screen/internal/encoders"
+	"github.com/rviscarra/webrtc-remote-screen/internal/raudio"
+)
+
+// findAudioCodec returns the Opus codec of the offer, nil if the
+// client doesn't want audio
+func findAudioCodec(sdp *sdp.SessionDescription) *webrtc.RTPCodec {
+	for _, md := range sdp.MediaDescriptions {
+		if md.MediaName.Media != "audio" {
+			continue
+		}
+		for _, format := range md.MediaName.Formats {
+			intPt, err := strconv.Atoi(format)
+			if err != nil {
+				continue
+			}
+			payloadType := uint8(intPt)
+			sdpCodec, err := sdp.GetCodecForPayloadType(payloadType)
+			if err != nil {
+				continue
+			}
+			if strings.EqualFold(sdpCodec.Name, webrtc.Opus) {
+				codec := webrtc.NewRTPOpusCodec(payloadType, sdpCodec.ClockRate)
+				codec.SDPFmtpLine = sdpCodec.Fmtp
+				return codec
+			}
+		}
+	}
+	return nil
+}
+
+// audioBroadcaster captures and encodes the audio once for every session,
+// the capture only runs while there are subscribed tracks. If it fails it's
+// restarted when a track subscribes
+type audioBroadcaster struct {
+	service    raudio.Service
+	encService encoders.Service
+
+	mutex   sync.Mutex
+	tracks  map[*webrtc.Track]struct{}
+	grabber raudio.AudioGrabber
+	done    chan struct{}
+}
+
+func newAudioBroadcaster(service raudio.Service, encService encoders.Service) *audioBroadcaster {
+	return &audioBroadcaster{
+		service:    service,
+		encService: encService,
+		tracks:     make(map[*webrtc.Track]struct{}),
+	}
+}
+
+// subscribe adds a track, the first one starts the capture
+func (b *audioBroadcaster) subscribe(track *webrtc.Track) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.grabber == nil {
+		grabber, err := b.service.CreateAudioGrabber()
+		if err != nil {
+			return err
+		}
+		encoder, err := b.encService.NewAudioEncoder(raudio.SampleRate, raudio.Channels)
+		if err != nil {
+			return err
+		}
+		b.grabber = grabber
+		b.done = make(chan struct{})
+		grabber.Start()
+		go b.run(grabber, encoder, b.done)
+	}
+	b.tracks[track] = struct{}{}
+	return nil
+}
+
+// unsubscribe removes a track, the capture stops with the last one
+func (b *audioBroadcaster) unsubscribe(track *webrtc.Track) {
+	b.mutex.Lock()
+	if _, found := b.tracks[track]; !found {
+		b.mutex.Unlock()
+		return
+	}
+	delete(b.tracks, track)
+	var grabber raudio.AudioGrabber
+	var done chan struct{}
+	if len(b.tracks) == 0 {
+		grabber, done = b.grabber, b.done
+		b.grabber = nil
+	}
+	b.mutex.Unlock()
+
+	if grabber != nil {
+		grabber.Stop()
+		<-done
+	}
+}
+
+func (b *audioBroadcaster) run(grabber raudio.AudioGrabber, encoder encoders.AudioEncoder, done chan struct{}) {
+	defer close(done)
+	defer encoder.Close()
+	for frame := range grabber.Frames() {
+		payload, err := encoder.Encode(frame)
+		if err != nil {
+			log.Printf("Audio: %v", err)
+			continue
+		}
+		sample := media.Sample{
+			Data:    payload,
+			Samples: raudio.FrameSamples,
+		}
+		b.mutex.Lock()
+		for track := range b.tracks {
+			if err := track.WriteSample(sample); err != nil {
+				log.Printf("Audio: can't write to track %s: %v", track.ID(), err)
+			}
+		}
+		b.mutex.Unlock()
+	}
+
+	// Unless it was stopped the capture failed (e.g. parec exited), the
+	// next subscriber starts a new one
+	b.mutex.Lock()
+	failed := b.grabber == grabber
+	if failed {
+		b.grabber = nil
+	}
+	b.mutex.Unlock()
+	if failed {
+		log.Printf("Audio: the capture ended, it will be restarted for the next session")
+		grabber.Stop()
+	}
+}
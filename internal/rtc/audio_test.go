@@ -0,0 +1,73 @@
+package rtc
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rviscarra/webrtc-remote-screen/internal/encoders"
+	"github.com/rviscarra/webrtc-remote-screen/internal/raudio"
+)
+
+// endingAudioService creates grabbers whose capture ends right away,
+// as when parec exits
+type endingAudioService struct {
+	created int32
+}
+
+func (s *endingAudioService) CreateAudioGrabber() (raudio.AudioGrabber, error) {
+	atomic.AddInt32(&s.created, 1)
+	return &endingGrabber{frames: make(chan []int16)}, nil
+}
+
+type endingGrabber struct {
+	frames  chan []int16
+	stopped int32
+}
+
+func (g *endingGrabber) Start()                 { close(g.frames) }
+func (g *endingGrabber) Frames() <-chan []int16 { return g.frames }
+func (g *endingGrabber) Stop() {
+	if !atomic.CompareAndSwapInt32(&g.stopped, 0, 1) {
+		panic("The grabber was stopped twice")
+	}
+}
+
+type nullAudioEncoder struct{}
+
+func (nullAudioEncoder) Encode([]int16) ([]byte, error) { return nil, errors.New("No audio") }
+func (nullAudioEncoder) Close() error                   { return nil }
+
+type audioEncoderService struct {
+	failingEncoderService
+}
+
+func (*audioEncoderService) NewAudioEncoder(sampleRate, channels int) (encoders.AudioEncoder, error) {
+	return nullAudioEncoder{}, nil
+}
+
+func TestAudioCaptureRestart(t *testing.T) {
+	service := &endingAudioService{}
+	b := newAudioBroadcaster(service, &audioEncoderService{})
+	first := newTestTrack(t, 1)
+	if err := b.subscribe(first); err != nil {
+		t.Fatal(err)
+	}
+	b.mutex.Lock()
+	done := b.done
+	b.mutex.Unlock()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The capture loop didn't end")
+	}
+
+	if err := b.subscribe(newTestTrack(t, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if created := atomic.LoadInt32(&service.created); created != 2 {
+		t.Errorf("%d captures were started, expected a new one after the failure", created)
+	}
+	b.unsubscribe(first)
+}
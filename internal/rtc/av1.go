@@ -0,0 +1,94 @@
+package rtc
+
+import (
+	"log"
+
+	"github.com/pion/webrtc/v2"
+	"github.com/rviscarra/webrtc-remote-screen/internal/encoders"
+	"github.com/rviscarra/webrtc-remote-screen/internal/recording"
+)
+
+// AV1 isn't known to Pion v2, the codec is registered by name
+const av1CodecName = "AV1"
+
+// av1Payloader splits AV1 temporal units in RTP payloads (RTP Payload
+// Format for AV1, 4). Each OBU is an element prefixed with its length
+// (W=0), the temporal delimiters and tile lists are left out as the
+// format requires
+type av1Payloader struct{}
+
+// Payload fragments an AV1 temporal unit across one or more payloads
+func (p *av1Payloader) Payload(mtu int, payload []byte) [][]byte {
+	/*
+	 *       0 1 2 3 4 5 6 7
+	 *      +-+-+-+-+-+-+-+-+
+	 *      |Z|Y| W |N|-|-|-|
+	 *      +-+-+-+-+-+-+-+-+
+	 * Z: the first element continues the last one of the previous packet
+	 * Y: the last element continues in the next packet
+	 * N: first packet of a coded video sequence
+	 */
+	obus, err := encoders.SplitOBUs(payload)
+	if err != nil {
+		log.Printf("AV1: %v", err)
+		return nil
+	}
+	if mtu < 3 {
+		return nil
+	}
+	var payloads [][]byte
+	packet := []byte{0}
+	for _, obu := range obus {
+		if obu.Type == encoders.OBUTemporalDelimiter || obu.Type == encoders.OBUTileList {
+			continue
+		}
+		element := append(obu.Header, obu.Payload...)
+		for {
+			room := mtu - len(packet)
+			chunk := len(element)
+			if leb128Size(chunk)+chunk > room {
+				chunk = room - leb128Size(room)
+			}
+			if chunk <= 0 {
+				payloads = append(payloads, packet)
+				packet = []byte{0}
+				continue
+			}
+			packet = encoders.AppendLEB128(packet, uint64(chunk))
+			packet = append(packet, element[:chunk]...)
+			element = element[chunk:]
+			if len(element) == 0 {
+				break
+			}
+			packet[0] |= 0x40
+			payloads = append(payloads, packet)
+			packet = []byte{0x80}
+		}
+	}
+	if len(packet) > 1 {
+		payloads = append(payloads, packet)
+	}
+	if len(payloads) > 0 && recording.IsKeyFrame(encoders.AV1Codec, payload) {
+		payloads[0][0] |= 0x08
+	}
+	return payloads
+}
+
+// leb128Size returns the length of value encoded as LEB128
+func leb128Size(value int) int {
+	size := 1
+	for ; value >= 0x80; value >>= 7 {
+		size++
+	}
+	return size
+}
+
+// av1Profile returns the profile of an AV1 fmtp line, 0 (main) if missing
+func av1Profile(fmtp string) string {
+	return fmtpParam(fmtp, "profile", "0")
+}
+
+// newAV1Codec creates the codec with its payloader
+func newAV1Codec(payloadType uint8, clockRate uint32, fmtp string) *webrtc.RTPCodec {
+	return webrtc.NewRTPCodec(webrtc.RTPCodecTypeVideo, av1CodecName, clockRate, 0, fmtp, payloadType, &av1Payloader{})
+}
@@ -0,0 +1,534 @@
+package rtc
+
+import (
+	"bytes"
+	"image"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v2"
+	"github.com/pion/webrtc/v2/pkg/media"
+	"github.com/rviscarra/webrtc-remote-screen/internal/encoders"
+	"github.com/rviscarra/webrtc-remote-screen/internal/rdisplay"
+)
+
+// Receivers tend to send bursts of PLIs, keyframe requests closer
+// than this are ignored
+const minKeyFrameInterval = 500 * time.Millisecond
+
+// broadcastKey identifies a capture & encode pipeline that can be shared
+// by every viewer of the same screen
+type broadcastKey struct {
+	screen    int
+	codec     encoders.VideoCodec
+	size      image.Point
+	fps       int
+	cursor    bool
+	chroma444 bool
+}
+
+// screenBroadcaster owns a single screen grabber and encoder, the encoded
+// samples are written to every subscribed track
+type screenBroadcaster struct {
+	key       broadcastKey
+	grabber   rdisplay.ScreenGrabber
+	encoder   encoders.Encoder
+	rates     *rateController
+	videoSize image.Point
+	refs      int
+	// cursors is set when the cursor is composited into the frames
+	cursors rdisplay.CursorService
+	bounds  image.Rectangle
+	tracker rdisplay.CursorTracker
+	// Unchanged frames are only encoded every idleInterval, zero encodes
+	// every frame
+	idleInterval time.Duration
+	previous     *image.RGBA
+	lastEncoded  time.Time
+
+	mutex  sync.Mutex
+	tracks map[*webrtc.Track]struct{}
+	taps   map[chan *image.RGBA]struct{}
+	// recorders get the same samples as the tracks
+	recorders map[*sessionRecorder]struct{}
+	started   bool
+	ended     bool
+	// failed is set when the capture loop ended on its own, after an
+	// encoder or grabber error, instead of being stopped
+	failed bool
+	stop   chan struct{}
+	done   chan struct{}
+	// onFailure is called once a failed capture loop ended, before done
+	// is closed
+	onFailure func(*screenBroadcaster)
+
+	lastKeyFrameRequest time.Time
+	// keyFramePending forces encoding the next frame even if unchanged
+	keyFramePending bool
+	stats           FrameStats
+}
+
+func newScreenBroadcaster(key broadcastKey, grabber rdisplay.ScreenGrabber, encoder encoders.Encoder, bitrates BitrateLimits, initialBitrate int) (*screenBroadcaster, error) {
+	videoSize, err := encoder.VideoSize()
+	if err != nil {
+		return nil, err
+	}
+	return &screenBroadcaster{
+		key:       key,
+		grabber:   grabber,
+		encoder:   encoder,
+		rates:     newRateController(bitrates, encoder, initialBitrate),
+		videoSize: videoSize,
+		tracks:    make(map[*webrtc.Track]struct{}),
+		taps:      make(map[chan *image.RGBA]struct{}),
+		recorders: make(map[*sessionRecorder]struct{}),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}, nil
+}
+
+// subscribe adds a track to the broadcast, the capture loop is started
+// when the first track subscribes. maxBitrate caps the encoder bitrate
+// while the track is subscribed, zero means no cap
+func (b *screenBroadcaster) subscribe(track *webrtc.Track, maxBitrate int) {
+	b.rates.addViewer(track, maxBitrate)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.tracks[track] = struct{}{}
+	if !b.started {
+		b.started = true
+		go b.run()
+		return
+	}
+	// Get the new viewer going without waiting for a PLI
+	b.keyFrame()
+}
+
+// keyFrame requests a keyframe to the encoder, the mutex must be held
+func (b *screenBroadcaster) keyFrame() {
+	b.keyFramePending = true
+	b.encoder.RequestKeyFrame()
+}
+
+// addRecorder starts writing the samples to the recorder, a keyframe is
+// requested since recordings must start with one
+func (b *screenBroadcaster) addRecorder(recorder *sessionRecorder) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.recorders[recorder] = struct{}{}
+	b.keyFrame()
+}
+
+func (b *screenBroadcaster) removeRecorder(recorder *sessionRecorder) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.recorders, recorder)
+}
+
+// requestKeyFrame asks the encoder for a keyframe on behalf of a viewer
+func (b *screenBroadcaster) requestKeyFrame() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(b.lastKeyFrameRequest) < minKeyFrameInterval {
+		return
+	}
+	b.lastKeyFrameRequest = now
+	b.keyFrame()
+}
+
+func (b *screenBroadcaster) unsubscribe(track *webrtc.Track) {
+	b.mutex.Lock()
+	delete(b.tracks, track)
+	b.mutex.Unlock()
+	b.rates.removeViewer(track)
+}
+
+func (b *screenBroadcaster) running() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.started && !b.ended
+}
+
+// hasEnded checks if the capture loop ended, a broadcaster can't be
+// started again
+func (b *screenBroadcaster) hasEnded() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.ended
+}
+
+// hasFailed checks if the capture loop ended without being stopped
+func (b *screenBroadcaster) hasFailed() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.failed
+}
+
+// addTap returns a channel that receives the captured frames, before
+// they are scaled and encoded. It's closed when the capture loop ends,
+// nil is returned if it isn't running
+func (b *screenBroadcaster) addTap() chan *image.RGBA {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !b.started || b.ended {
+		return nil
+	}
+	tap := make(chan *image.RGBA, 1)
+	b.taps[tap] = struct{}{}
+	return tap
+}
+
+func (b *screenBroadcaster) removeTap(tap chan *image.RGBA) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, found := b.taps[tap]; found {
+		delete(b.taps, tap)
+		close(tap)
+	}
+}
+
+// end is called once the capture loop ends, it closes the taps and
+// returns whether the loop failed
+func (b *screenBroadcaster) end() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.ended = true
+	for tap := range b.taps {
+		close(tap)
+	}
+	b.taps = make(map[chan *image.RGBA]struct{})
+	select {
+	case <-b.stop:
+	default:
+		b.failed = true
+	}
+	return b.failed
+}
+
+func (b *screenBroadcaster) run() {
+	defer close(b.done)
+	defer func() {
+		if b.end() {
+			log.Printf("Broadcaster: the capture of screen %d ended unexpectedly", b.key.screen)
+			if b.onFailure != nil {
+				b.onFailure(b)
+			}
+		}
+	}()
+	if b.cursors != nil {
+		tracker, err := b.cursors.CreateCursorTracker()
+		if err != nil {
+			log.Printf("Broadcaster: the cursor won't be drawn: %v", err)
+		} else {
+			b.tracker = tracker
+			defer b.closeTracker()
+		}
+	}
+	b.grabber.Start()
+	// The capture runs on its own so a slow encoder doesn't hold it back,
+	// the encoder always gets the latest frame
+	slot := newFrameSlot()
+	failed := make(chan struct{})
+	captureDone := make(chan struct{})
+	go b.capture(slot, failed, captureDone)
+	defer func() { <-captureDone }()
+	for {
+		frame, capturedAt, ok := slot.take()
+		if !ok {
+			return
+		}
+		if err := b.broadcast(frame, capturedAt); err != nil {
+			log.Printf("Broadcaster: %v", err)
+			close(failed)
+			return
+		}
+	}
+}
+
+// capture moves the grabber frames to the slot until the broadcaster is
+// stopped, the encoder fails or the grabber ends
+func (b *screenBroadcaster) capture(slot *frameSlot, failed <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	defer slot.close()
+	frames := b.grabber.Frames()
+	for {
+		select {
+		case <-b.stop:
+			b.grabber.Stop()
+			return
+		case <-failed:
+			b.grabber.Stop()
+			return
+		case frame, ok := <-frames:
+			if !ok {
+				return
+			}
+			dropped := slot.put(frame, time.Now())
+			b.mutex.Lock()
+			b.stats.Captured++
+			if dropped {
+				b.stats.Dropped++
+			}
+			b.mutex.Unlock()
+		}
+	}
+}
+
+// closeTracker stops drawing the cursor, it's only called by the capture loop
+func (b *screenBroadcaster) closeTracker() {
+	if b.tracker != nil {
+		b.tracker.Close()
+		b.tracker = nil
+	}
+}
+
+// frameStats returns a snapshot of the broadcast statistics
+func (b *screenBroadcaster) frameStats() FrameStats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.stats
+}
+
+// Weight of the last frame in the average latency
+const latencyWeight = 0.1
+
+func (b *screenBroadcaster) broadcast(frame *image.RGBA, capturedAt time.Time) error {
+	if b.tracker != nil {
+		// Losing the cursor isn't worth stopping the video for its viewers
+		cursor, err := b.tracker.Cursor()
+		if err != nil {
+			log.Printf("Broadcaster: the cursor won't be drawn anymore: %v", err)
+			b.closeTracker()
+		} else {
+			rdisplay.DrawCursor(frame, b.bounds, cursor)
+		}
+	}
+	b.mutex.Lock()
+	var tapFrame *image.RGBA
+	for tap := range b.taps {
+		// The grabber may reuse the frame before the taps are done with it
+		if tapFrame == nil {
+			tapFrame = cloneFrame(frame)
+		}
+		// Taps must not slow down the WebRTC viewers, a busy one skips the frame
+		select {
+		case tap <- tapFrame:
+		default:
+		}
+	}
+	keyFrame := b.keyFramePending
+	b.keyFramePending = false
+	b.mutex.Unlock()
+
+	if !keyFrame && b.unchanged(frame) {
+		b.mutex.Lock()
+		b.stats.Unchanged++
+		b.mutex.Unlock()
+		return nil
+	}
+	b.keepPrevious(frame)
+	b.lastEncoded = time.Now()
+
+	if frame.Rect.Size() != b.videoSize {
+		frame = resizeImage(frame, b.videoSize)
+	}
+	payload, err := b.encoder.Encode(frame)
+	if err != nil {
+		return err
+	}
+	if payload == nil {
+		return nil
+	}
+	sample := media.Sample{
+		Data:    payload,
+		Samples: 1,
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for track := range b.tracks {
+		if err := track.WriteSample(sample); err != nil {
+			log.Printf("Broadcaster: can't write to track %s: %v", track.ID(), err)
+		}
+	}
+	for recorder := range b.recorders {
+		recorder.write(payload, b.videoSize)
+	}
+	latency := time.Since(capturedAt)
+	if b.stats.Encoded > 0 {
+		latency = b.stats.Latency + time.Duration(latencyWeight*float64(latency-b.stats.Latency))
+	}
+	b.stats.Encoded++
+	b.stats.Latency = latency
+	return nil
+}
+
+// unchanged checks if the frame is the same as the last one encoded, even
+// then it's encoded once per idleInterval so the stream doesn't stall
+func (b *screenBroadcaster) unchanged(frame *image.RGBA) bool {
+	if b.idleInterval <= 0 || b.previous == nil || b.previous.Rect != frame.Rect {
+		return false
+	}
+	if time.Since(b.lastEncoded) >= b.idleInterval {
+		return false
+	}
+	return bytes.Equal(b.previous.Pix, frame.Pix)
+}
+
+// keepPrevious copies the frame for the next comparison, the grabber may
+// reuse its buffer
+func (b *screenBroadcaster) keepPrevious(frame *image.RGBA) {
+	if b.idleInterval <= 0 {
+		return
+	}
+	if b.previous == nil || b.previous.Rect != frame.Rect {
+		b.previous = cloneFrame(frame)
+		return
+	}
+	copy(b.previous.Pix, frame.Pix)
+}
+
+// close stops the capture loop, if running, and releases the encoder
+func (b *screenBroadcaster) close() {
+	b.mutex.Lock()
+	started := b.started
+	b.mutex.Unlock()
+	if started {
+		close(b.stop)
+		<-b.done
+	}
+	if err := b.encoder.Close(); err != nil {
+		log.Printf("Broadcaster: can't close encoder: %v", err)
+	}
+}
+
+// broadcasterRegistry keeps the running pipelines, reference counted
+// so each one is stopped when its last viewer leaves
+type broadcasterRegistry struct {
+	mutex        sync.Mutex
+	videoService rdisplay.Service
+	// cursorService is nil if the video provider can't track the cursor
+	cursorService rdisplay.CursorService
+	encService    encoders.Service
+	bitrates      BitrateLimits
+	idleFPS       int
+	broadcasters  map[broadcastKey]*screenBroadcaster
+}
+
+func newBroadcasterRegistry(video rdisplay.Service, enc encoders.Service, bitrates BitrateLimits, idleFPS int) *broadcasterRegistry {
+	cursors, _ := video.(rdisplay.CursorService)
+	return &broadcasterRegistry{
+		cursorService: cursors,
+		videoService:  video,
+		encService:    enc,
+		bitrates:      bitrates,
+		idleFPS:       idleFPS,
+		broadcasters:  make(map[broadcastKey]*screenBroadcaster),
+	}
+}
+
+// acquire returns the pipeline for the screen, codec, size and frame rate,
+// creating it if needed. Each call must be matched by a call to release
+func (r *broadcasterRegistry) acquire(screen rdisplay.Screen, codec encoders.VideoCodec, options StreamOptions) (*screenBroadcaster, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	key := broadcastKey{
+		screen:    screen.Index,
+		codec:     codec,
+		size:      options.videoSize(screen.Bounds.Size()),
+		fps:       options.FPS,
+		cursor:    options.Cursor == CursorComposite,
+		chroma444: options.Chroma444,
+	}
+	// A broadcaster that failed is replaced, even if the registry wasn't
+	// told yet
+	if b, found := r.broadcasters[key]; found && !b.hasEnded() {
+		b.refs++
+		return b, nil
+	}
+
+	grabber, err := r.videoService.CreateScreenGrabber(screen, options.FPS)
+	if err != nil {
+		return nil, err
+	}
+	bitrate := initialBitrate(r.bitrates, options.Bitrate)
+	encoder, err := r.encService.NewEncoder(codec, encoders.Options{
+		Size:      key.size,
+		FrameRate: options.FPS,
+		Bitrate:   bitrate,
+		Chroma444: key.chroma444,
+	})
+	if err != nil {
+		return nil, err
+	}
+	b, err := newScreenBroadcaster(key, grabber, encoder, r.bitrates, bitrate)
+	if err != nil {
+		encoder.Close()
+		return nil, err
+	}
+	if key.cursor {
+		b.cursors = r.cursorService
+		b.bounds = screen.Bounds
+	}
+	if r.idleFPS > 0 && r.idleFPS < options.FPS {
+		b.idleInterval = time.Second / time.Duration(r.idleFPS)
+	}
+	b.onFailure = r.forget
+	b.refs = 1
+	r.broadcasters[key] = b
+	return b, nil
+}
+
+// forget drops a failed broadcaster so the next acquire creates a new one,
+// the sessions that hold it still have to release it
+func (r *broadcasterRegistry) forget(b *screenBroadcaster) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.broadcasters[b.key] == b {
+		delete(r.broadcasters, b.key)
+	}
+}
+
+// tap attaches to a running broadcaster of the screen, if any
+func (r *broadcasterRegistry) tap(screenIx int) (*screenBroadcaster, chan *image.RGBA) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for key, b := range r.broadcasters {
+		if key.screen != screenIx {
+			continue
+		}
+		if tap := b.addTap(); tap != nil {
+			return b, tap
+		}
+	}
+	return nil, nil
+}
+
+// hasBroadcaster checks if the screen is being captured for WebRTC viewers
+func (r *broadcasterRegistry) hasBroadcaster(screenIx int) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for key, b := range r.broadcasters {
+		if key.screen == screenIx && b.running() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *broadcasterRegistry) release(b *screenBroadcaster) {
+	r.mutex.Lock()
+	b.refs--
+	last := b.refs == 0
+	// The key may belong to a new broadcaster if this one failed
+	if last && r.broadcasters[b.key] == b {
+		delete(r.broadcasters, b.key)
+	}
+	r.mutex.Unlock()
+
+	if last {
+		b.close()
+	}
+}
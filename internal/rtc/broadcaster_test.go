@@ -0,0 +1,111 @@
+package rtc
+
+import (
+	"errors"
+	"image"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rviscarra/webrtc-remote-screen/internal/encoders"
+	"github.com/rviscarra/webrtc-remote-screen/internal/rdisplay"
+)
+
+// failingEncoderService creates encoders that fail their first frame
+// while fail is set
+type failingEncoderService struct {
+	fail int32
+}
+
+func (s *failingEncoderService) NewEncoder(codec encoders.VideoCodec, opts encoders.Options) (encoders.Encoder, error) {
+	return &failingEncoder{size: opts.Size, fail: atomic.LoadInt32(&s.fail) == 1}, nil
+}
+
+func (s *failingEncoderService) Supports(codec encoders.VideoCodec) bool { return true }
+func (s *failingEncoderService) SupportsAudio() bool                     { return false }
+func (s *failingEncoderService) NewAudioEncoder(sampleRate, channels int) (encoders.AudioEncoder, error) {
+	return nil, errors.New("No audio encoder")
+}
+
+type failingEncoder struct {
+	size image.Point
+	fail bool
+}
+
+func (e *failingEncoder) Encode(*image.RGBA) ([]byte, error) {
+	if e.fail {
+		return nil, errors.New("Encoder failure")
+	}
+	return vp8KeyFrame, nil
+}
+
+func (e *failingEncoder) VideoSize() (image.Point, error) { return e.size, nil }
+func (e *failingEncoder) RequestKeyFrame()                {}
+func (e *failingEncoder) SetBitrate(int) error            { return nil }
+func (e *failingEncoder) Close() error                    { return nil }
+
+func waitEnded(t *testing.T, b *screenBroadcaster) {
+	t.Helper()
+	select {
+	case <-b.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The broadcaster didn't end")
+	}
+}
+
+func TestBroadcasterFailure(t *testing.T) {
+	video, err := rdisplay.NewTestPatternProvider(image.Point{64, 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	screens, _ := video.Screens()
+	encService := &failingEncoderService{fail: 1}
+	registry := newBroadcasterRegistry(video, encService, testLimits, 0)
+	options := StreamOptions{FPS: 30}
+
+	failed, err := registry.acquire(screens[0], encoders.VP8Codec, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed.subscribe(newTestTrack(t, 1), 0)
+	waitEnded(t, failed)
+	if !failed.hasFailed() {
+		t.Fatal("The encoder error wasn't reported as a failure")
+	}
+	registry.mutex.Lock()
+	registered := len(registry.broadcasters)
+	registry.mutex.Unlock()
+	if registered != 0 {
+		t.Error("The failed broadcaster is still registered")
+	}
+
+	atomic.StoreInt32(&encService.fail, 0)
+	live, err := registry.acquire(screens[0], encoders.VP8Codec, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live == failed {
+		t.Fatal("The failed broadcaster was acquired again")
+	}
+	live.subscribe(newTestTrack(t, 2), 0)
+	deadline := time.Now().Add(5 * time.Second)
+	for live.frameStats().Encoded == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("The new broadcaster doesn't encode")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Releasing the failed one leaves the new one registered
+	registry.release(failed)
+	if again, _ := registry.acquire(screens[0], encoders.VP8Codec, options); again != live {
+		t.Error("Releasing the failed broadcaster dropped the new one")
+	} else {
+		registry.release(again)
+	}
+	registry.release(live)
+	waitEnded(t, live)
+	if live.hasFailed() {
+		t.Error("A stopped broadcaster is reported as failed")
+	}
+}
@@ -0,0 +1,289 @@
+package rtc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp"
+	"github.com/pion/webrtc/v2"
+	"github.com/rviscarra/webrtc-remote-screen/internal/encoders"
+)
+
+// videoCodecs maps the SDP names to the encoders
+var videoCodecs = map[string]encoders.VideoCodec{
+	av1CodecName: encoders.AV1Codec,
+	webrtc.VP9:   encoders.VP9Codec,
+	webrtc.VP8:   encoders.VP8Codec,
+	webrtc.H264:  encoders.H264Codec,
+}
+
+// DefaultCodecs is the codec preference order when none is configured, VP8
+// and H.264 come first as before the order was configurable. AV1 and VP9 need
+// less bandwidth for screen content but have to be moved ahead to be picked
+var DefaultCodecs = []string{webrtc.VP8, webrtc.H264, webrtc.VP9, av1CodecName}
+
+// Formats of an offer that aren't codecs of their own
+var auxiliaryFormats = map[string]bool{
+	"RTX":        true,
+	"RED":        true,
+	"ULPFEC":     true,
+	"FLEXFEC-03": true,
+}
+
+// ParseCodecs parses a comma separated list of codec names, e.g. "vp8,h264"
+func ParseCodecs(list string) ([]string, error) {
+	var codecs []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if _, found := videoCodecs[name]; !found {
+			return nil, fmt.Errorf("Unknown codec %q", name)
+		}
+		if !containsCodec(codecs, name) {
+			codecs = append(codecs, name)
+		}
+	}
+	return codecs, nil
+}
+
+func containsCodec(codecs []string, name string) bool {
+	for _, codec := range codecs {
+		if codec == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportedCodecs returns the codecs of the list that have an encoder
+func SupportedCodecs(encService encoders.Service, codecs []string) []string {
+	var supported []string
+	for _, name := range codecs {
+		if encService.Supports(videoCodecs[name]) {
+			supported = append(supported, name)
+		}
+	}
+	return supported
+}
+
+// codecOrder returns the codecs a session can use in order: the ones the
+// client asked for, in its order, that the server allows. Without a client
+// preference the server order is used
+func codecOrder(allowed, requested []string) ([]string, error) {
+	if len(allowed) == 0 {
+		allowed = DefaultCodecs
+	}
+	if len(requested) == 0 {
+		return allowed, nil
+	}
+	var codecs []string
+	for _, name := range requested {
+		name = strings.ToUpper(name)
+		if _, found := videoCodecs[name]; !found {
+			return nil, ErrInvalidStreamOptions
+		}
+		if containsCodec(allowed, name) && !containsCodec(codecs, name) {
+			codecs = append(codecs, name)
+		}
+	}
+	if len(codecs) == 0 {
+		return nil, ErrInvalidStreamOptions
+	}
+	return codecs, nil
+}
+
+// OfferedCodec is a video format of an offer
+type OfferedCodec struct {
+	Name        string
+	PayloadType uint8
+	Fmtp        string
+	// Rejected tells why the format can't be used, empty if it can
+	Rejected string
+}
+
+// CodecMismatchError is returned when an offer has no video codec the
+// session can use
+type CodecMismatchError struct {
+	Offered []OfferedCodec
+	// Supported are the codecs the session could use, in preference order
+	Supported []string
+}
+
+func (e *CodecMismatchError) Error() string {
+	offered := make([]string, len(e.Offered))
+	for i, codec := range e.Offered {
+		offered[i] = fmt.Sprintf("%s/%d", codec.Name, codec.PayloadType)
+		if codec.Rejected != "" {
+			offered[i] += fmt.Sprintf(" (%s)", codec.Rejected)
+		}
+	}
+	return fmt.Sprintf("No common video codec, offered: %s; supported: %s",
+		strings.Join(offered, ", "), strings.Join(e.Supported, ", "))
+}
+
+// findBestCodec picks the video codec of the offer, following the order
+// of the codecs list. With chroma444 the VP9 profile 1 is picked if offered
+func findBestCodec(sdp *sdp.SessionDescription, encService encoders.Service, order []string, chroma444 bool) (*webrtc.RTPCodec, encoders.VideoCodec, error) {
+	supported := SupportedCodecs(encService, order)
+
+	// The best format of each codec, by rank and then offer order
+	candidates := make(map[string]*webrtc.RTPCodec)
+	ranks := make(map[string]int)
+	var offered []OfferedCodec
+	for _, md := range sdp.MediaDescriptions {
+		if md.MediaName.Media != "video" {
+			continue
+		}
+		for _, format := range md.MediaName.Formats {
+			intPt, err := strconv.Atoi(format)
+			if err != nil {
+				return nil, encoders.NoCodec, fmt.Errorf("Invalid payload type %s", format)
+			}
+			payloadType := uint8(intPt)
+			sdpCodec, err := sdp.GetCodecForPayloadType(payloadType)
+			if err != nil {
+				return nil, encoders.NoCodec, fmt.Errorf("Can't find codec for %d", payloadType)
+			}
+			name := strings.ToUpper(sdpCodec.Name)
+			if auxiliaryFormats[name] {
+				continue
+			}
+			codec, rank, rejected := matchFormat(name, payloadType, sdpCodec, chroma444)
+			if codec != nil && !containsCodec(supported, name) {
+				codec, rejected = nil, "not supported by the agent"
+			}
+			offered = append(offered, OfferedCodec{
+				Name:        name,
+				PayloadType: payloadType,
+				Fmtp:        sdpCodec.Fmtp,
+				Rejected:    rejected,
+			})
+			if _, found := candidates[name]; codec != nil && (!found || rank > ranks[name]) {
+				candidates[name], ranks[name] = codec, rank
+			}
+		}
+	}
+	for _, name := range supported {
+		if codec, found := candidates[name]; found {
+			return codec, videoCodecs[name], nil
+		}
+	}
+	return nil, encoders.NoCodec, &CodecMismatchError{Offered: offered, Supported: supported}
+}
+
+// matchFormat creates the codec for an offered format, the rank prefers a
+// format among the ones of the same codec. It returns why the format
+// can't be used otherwise
+func matchFormat(name string, payloadType uint8, sdpCodec sdp.Codec, chroma444 bool) (*webrtc.RTPCodec, int, string) {
+	switch name {
+	case webrtc.H264:
+		rank, rejected := matchH264(sdpCodec.Fmtp)
+		if rejected != "" {
+			return nil, 0, rejected
+		}
+		codec := webrtc.NewRTPH264Codec(payloadType, sdpCodec.ClockRate)
+		codec.SDPFmtpLine = sdpCodec.Fmtp
+		return codec, rank, ""
+	case webrtc.VP8:
+		codec := webrtc.NewRTPVP8Codec(payloadType, sdpCodec.ClockRate)
+		codec.SDPFmtpLine = sdpCodec.Fmtp
+		return codec, 0, ""
+	case webrtc.VP9:
+		// Profile 2 is 10 bit, the encoder only does 8 bit
+		switch profile := vp9Profile(sdpCodec.Fmtp); profile {
+		case "0":
+			return newVP9Codec(payloadType, sdpCodec.ClockRate, sdpCodec.Fmtp), 0, ""
+		case "1":
+			if !chroma444 {
+				return nil, 0, "profile 1 (4:4:4) wasn't requested"
+			}
+			return newVP9Codec(payloadType, sdpCodec.ClockRate, sdpCodec.Fmtp), 1, ""
+		default:
+			return nil, 0, fmt.Sprintf("profile %s isn't supported", profile)
+		}
+	case av1CodecName:
+		// Only the main profile (4:2:0) is encoded
+		if profile := av1Profile(sdpCodec.Fmtp); profile != "0" {
+			return nil, 0, fmt.Sprintf("profile %s isn't supported", profile)
+		}
+		return newAV1Codec(payloadType, sdpCodec.ClockRate, sdpCodec.Fmtp), 0, ""
+	}
+	return nil, 0, "unknown codec"
+}
+
+// The H.264 encoder output is constrained baseline, level 3.1
+const (
+	h264ConstrainedBaseline = "Constrained Baseline"
+	h264EncoderLevel        = 31
+	// level_idc has no value for level 1b, it's signaled with the
+	// constraint_set3 flag
+	h264Level1b = 9
+)
+
+// h264Profiles identifies the profiles from profile_idc and the profile_iop
+// bits that matter (RFC 6184 8.1, as WebRTC implementations do)
+var h264Profiles = []struct {
+	idc     byte
+	mask    byte
+	pattern byte
+	name    string
+}{
+	{0x42, 0x4f, 0x40, h264ConstrainedBaseline},
+	{0x4d, 0x8f, 0x80, h264ConstrainedBaseline},
+	{0x58, 0xcf, 0xc0, h264ConstrainedBaseline},
+	{0x42, 0x4f, 0x00, "Baseline"},
+	{0x58, 0xcf, 0x80, "Baseline"},
+	{0x4d, 0xaf, 0x00, "Main"},
+	{0x64, 0xff, 0x00, "High"},
+	{0x64, 0xff, 0x0c, "Constrained High"},
+}
+
+// parseH264ProfileLevelID returns the profile and level of a
+// profile-level-id, these profiles can all decode constrained baseline
+func parseH264ProfileLevelID(id string) (string, int, error) {
+	value, err := strconv.ParseUint(id, 16, 32)
+	if len(id) != 6 || err != nil {
+		return "", 0, fmt.Errorf("invalid profile-level-id %s", id)
+	}
+	idc, iop, level := byte(value>>16), byte(value>>8), int(byte(value))
+	for _, profile := range h264Profiles {
+		if idc != profile.idc || iop&profile.mask != profile.pattern {
+			continue
+		}
+		if level == 11 && iop&0x10 != 0 && idc != 0x64 {
+			level = h264Level1b
+		}
+		return profile.name, level, nil
+	}
+	return "", 0, fmt.Errorf("profile-level-id %s isn't supported", id)
+}
+
+func h264LevelName(level int) string {
+	if level == h264Level1b {
+		return "1b"
+	}
+	return fmt.Sprintf("%d.%d", level/10, level%10)
+}
+
+// matchH264 checks the encoder output can be sent with the fmtp parameters
+// (RFC 6184 8.1), constrained baseline formats rank higher since they
+// describe the stream exactly
+func matchH264(fmtp string) (int, string) {
+	if mode := fmtpParam(fmtp, "packetization-mode", "0"); mode != "1" {
+		return 0, fmt.Sprintf("packetization-mode %s isn't supported", mode)
+	}
+	// Without profile-level-id the receiver only decodes baseline, level 1
+	profile, level, err := parseH264ProfileLevelID(fmtpParam(fmtp, "profile-level-id", "42000a"))
+	if err != nil {
+		return 0, err.Error()
+	}
+	// With level-asymmetry-allowed the offered level only applies to the
+	// stream sent by the browser
+	if level < h264EncoderLevel && fmtpParam(fmtp, "level-asymmetry-allowed", "0") != "1" {
+		return 0, fmt.Sprintf("level %s is below %s", h264LevelName(level), h264LevelName(h264EncoderLevel))
+	}
+	if profile == h264ConstrainedBaseline {
+		return 1, ""
+	}
+	return 0, ""
+}
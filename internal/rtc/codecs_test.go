@@ -0,0 +1,149 @@
+package rtc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v2"
+)
+
+func TestParseCodecs(t *testing.T) {
+	tests := []struct {
+		list     string
+		expected []string
+	}{
+		{"vp8,h264", []string{webrtc.VP8, webrtc.H264}},
+		{" AV1 , vp9,vp9", []string{av1CodecName, webrtc.VP9}},
+		{"vp8,theora", nil},
+		{"", nil},
+	}
+	for _, test := range tests {
+		codecs, err := ParseCodecs(test.list)
+		if test.expected == nil {
+			if err == nil {
+				t.Errorf("ParseCodecs(%q) = %v, expected an error", test.list, codecs)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(codecs, test.expected) {
+			t.Errorf("ParseCodecs(%q) = %v, %v, expected %v", test.list, codecs, err, test.expected)
+		}
+	}
+}
+
+func TestCodecOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowed   []string
+		requested []string
+		expected  []string
+	}{
+		{"defaults", nil, nil, DefaultCodecs},
+		{"server order", []string{webrtc.H264, webrtc.VP8}, nil, []string{webrtc.H264, webrtc.VP8}},
+		{"client order", nil, []string{"vp9", "h264"}, []string{webrtc.VP9, webrtc.H264}},
+		{"client duplicates", nil, []string{"h264", "H264"}, []string{webrtc.H264}},
+		{"not allowed left out", []string{webrtc.VP8, webrtc.H264}, []string{"av1", "h264"}, []string{webrtc.H264}},
+		{"unknown codec", nil, []string{"vp8", "theora"}, nil},
+		{"nothing allowed", []string{webrtc.VP8}, []string{"h264"}, nil},
+	}
+	for _, test := range tests {
+		codecs, err := codecOrder(test.allowed, test.requested)
+		if test.expected == nil {
+			if err != ErrInvalidStreamOptions {
+				t.Errorf("%s: got %v, %v, expected ErrInvalidStreamOptions", test.name, codecs, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(codecs, test.expected) {
+			t.Errorf("%s: got %v, %v, expected %v", test.name, codecs, err, test.expected)
+		}
+	}
+}
+
+func TestDefaultCodecsOrder(t *testing.T) {
+	// VP9 and AV1 are opt in, the negotiation picks VP8 or H.264 as
+	// before the preference was configurable
+	if !reflect.DeepEqual(DefaultCodecs[:2], []string{webrtc.VP8, webrtc.H264}) {
+		t.Errorf("The default codec order is %v", DefaultCodecs)
+	}
+}
+
+func TestParseH264ProfileLevelID(t *testing.T) {
+	tests := []struct {
+		id      string
+		profile string
+		level   int
+	}{
+		{"42e01f", h264ConstrainedBaseline, 31},
+		{"42c01f", h264ConstrainedBaseline, 31},
+		{"4d801f", h264ConstrainedBaseline, 31},
+		{"58c01f", h264ConstrainedBaseline, 31},
+		{"42001f", "Baseline", 31},
+		{"588028", "Baseline", 40},
+		{"4d001f", "Main", 31},
+		{"640032", "High", 50},
+		{"640c1f", "Constrained High", 31},
+		{"42000a", "Baseline", 10},
+		// Level 1b is level_idc 11 with constraint_set3
+		{"42f00b", h264ConstrainedBaseline, h264Level1b},
+		{"42e00b", h264ConstrainedBaseline, 11},
+		// constraint_set3 doesn't mean 1b for the High profiles
+		{"64100b", "", 0},
+		{"", "", 0},
+		{"42e0", "", 0},
+		{"42e01f00", "", 0},
+		{"42e0zz", "", 0},
+		{"f4001f", "", 0},
+		{"6e001f", "", 0},
+	}
+	for _, test := range tests {
+		profile, level, err := parseH264ProfileLevelID(test.id)
+		if test.profile == "" {
+			if err == nil {
+				t.Errorf("parseH264ProfileLevelID(%q) = %q, %d, expected an error", test.id, profile, level)
+			}
+			continue
+		}
+		if err != nil || profile != test.profile || level != test.level {
+			t.Errorf("parseH264ProfileLevelID(%q) = %q, %d, %v, expected %q, %d",
+				test.id, profile, level, err, test.profile, test.level)
+		}
+	}
+}
+
+func TestMatchH264(t *testing.T) {
+	tests := []struct {
+		fmtp     string
+		rank     int
+		rejected string
+	}{
+		{"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f", 1, ""},
+		{"packetization-mode=1;profile-level-id=42e01f", 1, ""},
+		{"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=4d001f", 0, ""},
+		{"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=640032", 0, ""},
+		{"packetization-mode=1;profile-level-id=42e032", 1, ""},
+		{"level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=42e01f", 0, "packetization-mode 0"},
+		{"level-asymmetry-allowed=1;profile-level-id=42e01f", 0, "packetization-mode 0"},
+		// The level only restricts the stream sent by the browser
+		{"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e00d", 1, ""},
+		{"packetization-mode=1;profile-level-id=42e01e", 0, "level 3.0 is below 3.1"},
+		{"packetization-mode=1;profile-level-id=42f00b", 0, "level 1b is below 3.1"},
+		// Without profile-level-id it's baseline level 1
+		{"packetization-mode=1", 0, "level 1.0 is below 3.1"},
+		{"level-asymmetry-allowed=1;packetization-mode=1", 0, ""},
+		{"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=f4001f", 0, "isn't supported"},
+	}
+	for _, test := range tests {
+		rank, rejected := matchH264(test.fmtp)
+		if test.rejected == "" {
+			if rejected != "" || rank != test.rank {
+				t.Errorf("matchH264(%q) = %d, %q, expected rank %d", test.fmtp, rank, rejected, test.rank)
+			}
+			continue
+		}
+		if !strings.Contains(rejected, test.rejected) {
+			t.Errorf("matchH264(%q) = %d, %q, expected a rejection with %q", test.fmtp, rank, rejected, test.rejected)
+		}
+	}
+}
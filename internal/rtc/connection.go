@@ -1,12 +1,12 @@
 package rtc
 
 import (
+	"errors"
 	"fmt"
-	"image"
 	"log"
 	"math/rand"
-	"strconv"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/pion/sdp"
@@ -22,55 +22,121 @@ type RemoteScreenPeerConn struct {
 	stunServer string
 	track      *webrtc.Track
 	streamer   videoStreamer
-	grabber    rdisplay.ScreenGrabber
+	screen     rdisplay.Screen
+	options    StreamOptions
+	recordOpts RecordingOptions
+	registry   *broadcasterRegistry
 	encService encoders.Service
+	input      rdisplay.InputInjector
+
+	id         string
+	remoteAddr string
+	startedAt  time.Time
+	onClose    func()
+	closeOnce  sync.Once
+	closeErr   error
+
+	mutex        sync.Mutex
+	state        webrtc.ICEConnectionState
+	codec        string
+	connectTimer *time.Timer
+	candidates   *candidateQueue
+	webrtcCodec  *webrtc.RTPCodec
+	encCodec     encoders.VideoCodec
+	switchMutex  sync.Mutex
+	recorder     *sessionRecorder
+	audio        *audioBroadcaster
+	audioCodec   *webrtc.RTPCodec
+	audioTrack   *webrtc.Track
+	mapping      *screenMapping
+	inputHandler *inputHandler
+	cursor       *cursorChannel
+	signaler     Signaler
+	pending      *pendingConnection
+	restartedAt  time.Time
+	done         chan struct{}
 }
 
-func findBestCodec(sdp *sdp.SessionDescription, encService encoders.Service, h264Profile string) (*webrtc.RTPCodec, encoders.VideoCodec, error) {
-	var h264Codec *webrtc.RTPCodec
-	var vp8Codec *webrtc.RTPCodec
-	for _, md := range sdp.MediaDescriptions {
-		for _, format := range md.MediaName.Formats {
-			intPt, err := strconv.Atoi(format)
-			payloadType := uint8(intPt)
-			sdpCodec, err := sdp.GetCodecForPayloadType(payloadType)
-			if err != nil {
-				return nil, encoders.NoCodec, fmt.Errorf("Can't find codec for %d", payloadType)
-			}
+// pendingConnection is a peer connection being renegotiated, it replaces
+// the current one once it gets connected
+type pendingConnection struct {
+	connection *webrtc.PeerConnection
+	track      *webrtc.Track
+	sender     *webrtc.RTPSender
+	audioTrack *webrtc.Track
+}
 
-			if sdpCodec.Name == webrtc.H264 && h264Codec == nil {
-				packetSupport := strings.Contains(sdpCodec.Fmtp, "packetization-mode=1")
-				supportsProfile := strings.Contains(sdpCodec.Fmtp, fmt.Sprintf("profile-level-id=%s", h264Profile))
-				if packetSupport && supportsProfile {
-					h264Codec = webrtc.NewRTPH264Codec(payloadType, sdpCodec.ClockRate)
-					h264Codec.SDPFmtpLine = sdpCodec.Fmtp
-				}
-			} else if sdpCodec.Name == webrtc.VP8 && vp8Codec == nil {
-				vp8Codec = webrtc.NewRTPVP8Codec(payloadType, sdpCodec.ClockRate)
-				vp8Codec.SDPFmtpLine = sdpCodec.Fmtp
-			}
-		}
+// ErrRenegotiationUnsupported is returned when the session signaling
+// can't carry server initiated offers
+var ErrRenegotiationUnsupported = errors.New("The session signaling doesn't support renegotiation")
+
+func newRemoteScreenPeerConn(stunServer string, screen rdisplay.Screen, options StreamOptions, recordOpts RecordingOptions, registry *broadcasterRegistry, audio *audioBroadcaster, encService encoders.Service, input rdisplay.InputInjector, remoteAddr string) *RemoteScreenPeerConn {
+	p := &RemoteScreenPeerConn{
+		id:         uuid.New().String(),
+		remoteAddr: remoteAddr,
+		startedAt:  time.Now(),
+		state:      webrtc.ICEConnectionStateNew,
+		stunServer: stunServer,
+		screen:     screen,
+		options:    options,
+		recordOpts: recordOpts,
+		audio:      audio,
+		registry:   registry,
+		encService: encService,
+		input:      input,
+		done:       make(chan struct{}),
 	}
-	if vp8Codec != nil && encService.Supports(encoders.VP8Codec) {
-		return vp8Codec, encoders.VP8Codec, nil
+	// Don't let the session leak if the client never connects
+	p.connectTimer = time.AfterFunc(sessionConnectTimeout, func() {
+		log.Printf("Session %s didn't connect in %v, closing it", p.id, sessionConnectTimeout)
+		p.Close()
+	})
+	return p
+}
+
+// ID returns the session identifier
+func (p *RemoteScreenPeerConn) ID() string {
+	return p.id
+}
+
+// Info returns a snapshot of the session state
+func (p *RemoteScreenPeerConn) Info() SessionInfo {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var recordingPath string
+	if p.recorder != nil {
+		recordingPath = p.recorder.currentPath()
+	}
+	var frames FrameStats
+	if p.streamer != nil {
+		frames = p.streamer.frameStats()
 	}
-	if h264Codec != nil && encService.Supports(encoders.H264Codec) {
-		return h264Codec, encoders.H264Codec, nil
+	return SessionInfo{
+		Frames:     frames,
+		Recording:  recordingPath,
+		ID:         p.id,
+		State:      p.state.String(),
+		Codec:      p.codec,
+		Screen:     p.screen.Index,
+		StartedAt:  p.startedAt,
+		RemoteAddr: p.remoteAddr,
 	}
-	return nil, encoders.NoCodec, fmt.Errorf("Couldn't find a matching codec")
 }
 
-func newRemoteScreenPeerConn(stunServer string, grabber rdisplay.ScreenGrabber, encService encoders.Service) *RemoteScreenPeerConn {
-	return &RemoteScreenPeerConn{
-		stunServer: stunServer,
-		grabber:    grabber,
-		encService: encService,
+// getFirstMid returns the media id of the first m= section, trickled candidates
+// are sent for it since everything is bundled
+func getFirstMid(sdp *sdp.SessionDescription) string {
+	for _, mediaDesc := range sdp.MediaDescriptions {
+		if mid, found := mediaDesc.Attribute("mid"); found {
+			return mid
+		}
 	}
+	return "0"
 }
 
-func getTrackDirection(sdp *sdp.SessionDescription) webrtc.RTPTransceiverDirection {
+func getTrackDirection(sdp *sdp.SessionDescription, media string) webrtc.RTPTransceiverDirection {
 	for _, mediaDesc := range sdp.MediaDescriptions {
-		if mediaDesc.MediaName.Media == "video" {
+		if mediaDesc.MediaName.Media == media {
 			if _, recvOnly := mediaDesc.Attribute("recvonly"); recvOnly {
 				return webrtc.RTPTransceiverDirectionRecvonly
 			} else if _, sendRecv := mediaDesc.Attribute("sendrecv"); sendRecv {
@@ -81,69 +147,172 @@ func getTrackDirection(sdp *sdp.SessionDescription) webrtc.RTPTransceiverDirecti
 	return webrtc.RTPTransceiverDirectionInactive
 }
 
-// ProcessOffer handles the SDP offer coming from the client,
-// return the SDP answer that must be passed back to stablish the WebRTC
-// connection.
-func (p *RemoteScreenPeerConn) ProcessOffer(strOffer string) (string, error) {
-	sdp := sdp.SessionDescription{}
-	err := sdp.Unmarshal(strOffer)
-	if err != nil {
-		return "", err
+// newPeerConnection creates a pion peer connection for the negotiated codec,
+// its state changes are reported to the session
+func (p *RemoteScreenPeerConn) newPeerConnection(trickle bool) (*webrtc.PeerConnection, error) {
+	mediaEngine := webrtc.MediaEngine{}
+	mediaEngine.RegisterCodec(p.webrtcCodec)
+	if p.audioCodec != nil {
+		mediaEngine.RegisterCodec(p.audioCodec)
 	}
 
-	webrtcCodec, encCodec, err := findBestCodec(&sdp, p.encService, "42e01f")
-	if err != nil {
-		return "", err
-	}
-	mediaEngine := webrtc.MediaEngine{}
-	mediaEngine.RegisterCodec(webrtcCodec)
+	settingEngine := webrtc.SettingEngine{}
+	settingEngine.SetTrickle(trickle)
 
-	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine))
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithSettingEngine(settingEngine))
 
 	pcconf := webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
+		SDPSemantics: webrtc.SDPSemanticsUnifiedPlan,
+	}
+	// Without a STUN server only the host candidates are gathered
+	if p.stunServer != "" {
+		pcconf.ICEServers = []webrtc.ICEServer{
 			webrtc.ICEServer{
 				URLs: []string{p.stunServer},
 			},
-		},
-		SDPSemantics: webrtc.SDPSemanticsUnifiedPlan,
+		}
 	}
 
 	peerConn, err := api.NewPeerConnection(pcconf)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	p.connection = peerConn
-
 	peerConn.OnICEConnectionStateChange(func(connState webrtc.ICEConnectionState) {
-		if connState == webrtc.ICEConnectionStateConnected {
-			p.start()
-		}
-		if connState == webrtc.ICEConnectionStateDisconnected {
-			p.Close()
-		}
-		log.Printf("Connection state: %s \n", connState.String())
+		p.onConnectionStateChange(peerConn, connState)
 	})
+	return peerConn, nil
+}
 
-	track, err := peerConn.NewTrack(
-		webrtcCodec.PayloadType,
+// newTrack creates a track for the codec, the audio and video tracks
+// share the stream label so the client plays them in sync
+func (p *RemoteScreenPeerConn) newTrack(peerConn *webrtc.PeerConnection, codec *webrtc.RTPCodec) (*webrtc.Track, error) {
+	return peerConn.NewTrack(
+		codec.PayloadType,
 		uint32(rand.Int31()),
 		uuid.New().String(),
 		fmt.Sprintf("remote-screen"),
 	)
+}
 
-	log.Printf("Using codec %s (%d) %s", webrtcCodec.Name, webrtcCodec.PayloadType, webrtcCodec.SDPFmtpLine)
-
-	direction := getTrackDirection(&sdp)
-
+// addTrack adds the track matching the direction of the client offer
+func addTrack(peerConn *webrtc.PeerConnection, track *webrtc.Track, direction webrtc.RTPTransceiverDirection) (*webrtc.RTPSender, error) {
 	if direction == webrtc.RTPTransceiverDirectionSendrecv {
-		_, err = peerConn.AddTrack(track)
+		return peerConn.AddTrack(track)
 	} else if direction == webrtc.RTPTransceiverDirectionRecvonly {
-		_, err = peerConn.AddTransceiverFromTrack(track, webrtc.RtpTransceiverInit{
+		transceiver, err := peerConn.AddTransceiverFromTrack(track, webrtc.RtpTransceiverInit{
 			Direction: webrtc.RTPTransceiverDirectionSendonly,
 		})
-	} else {
-		return "", fmt.Errorf("Unsupported transceiver direction")
+		if err != nil {
+			return nil, err
+		}
+		return transceiver.Sender, nil
+	}
+	return nil, fmt.Errorf("Unsupported transceiver direction")
+}
+
+// onConnectionStateChange handles the ICE state of both the current and
+// the pending peer connections, the ones already replaced are ignored
+func (p *RemoteScreenPeerConn) onConnectionStateChange(peerConn *webrtc.PeerConnection, connState webrtc.ICEConnectionState) {
+	p.mutex.Lock()
+	current := peerConn == p.connection
+	pending := p.pending != nil && peerConn == p.pending.connection
+	if current {
+		p.state = connState
+	}
+	p.mutex.Unlock()
+
+	switch {
+	case current:
+		if connState == webrtc.ICEConnectionStateConnected {
+			p.connectTimer.Stop()
+			p.start()
+		}
+		if connState == webrtc.ICEConnectionStateDisconnected || connState == webrtc.ICEConnectionStateFailed {
+			p.Close()
+		}
+		log.Printf("Session %s connection state: %s \n", p.id, connState.String())
+	case pending:
+		if connState == webrtc.ICEConnectionStateConnected {
+			p.promotePending()
+		}
+		if connState == webrtc.ICEConnectionStateFailed {
+			p.discardPending()
+		}
+		log.Printf("Session %s renegotiated connection state: %s \n", p.id, connState.String())
+	}
+}
+
+// ProcessOffer handles the SDP offer coming from the client,
+// return the SDP answer that must be passed back to stablish the WebRTC
+// connection. With trickle the answer is returned without waiting for
+// the ICE gathering, the candidates must be exchanged with AddICECandidate
+// and LocalCandidates
+func (p *RemoteScreenPeerConn) ProcessOffer(strOffer string, trickle bool) (string, error) {
+	sdp := sdp.SessionDescription{}
+	err := sdp.Unmarshal(strOffer)
+	if err != nil {
+		return "", err
+	}
+
+	webrtcCodec, encCodec, err := findBestCodec(&sdp, p.encService, p.options.Codecs, p.options.Chroma444)
+	if err != nil {
+		return "", err
+	}
+	if p.options.Chroma444 && !(encCodec == encoders.VP9Codec && vp9Profile(webrtcCodec.SDPFmtpLine) == "1") {
+		log.Printf("Session %s: 4:4:4 needs VP9 profile 1, streaming 4:2:0 \n", p.id)
+		p.options.Chroma444 = false
+	}
+	// Without these the receiver won't send PLI/FIR when it needs a keyframe,
+	// nor its bandwidth estimation (REMB)
+	webrtcCodec.RTCPFeedback = []webrtc.RTCPFeedback{
+		{Type: "nack", Parameter: "pli"},
+		{Type: "ccm", Parameter: "fir"},
+		{Type: "goog-remb"},
+	}
+	p.webrtcCodec = webrtcCodec
+	p.encCodec = encCodec
+
+	// Registered even without audio to send, so the answer can decline
+	// the audio section with a valid format
+	p.audioCodec = findAudioCodec(&sdp)
+	audioDirection := getTrackDirection(&sdp, "audio")
+
+	peerConn, err := p.newPeerConnection(trickle)
+	if err != nil {
+		return "", err
+	}
+	p.mutex.Lock()
+	p.connection = peerConn
+	p.mutex.Unlock()
+
+	if trickle {
+		p.candidates = newCandidateQueue(getFirstMid(&sdp))
+		peerConn.OnICECandidate(p.candidates.push)
+	}
+
+	track, err := p.newTrack(peerConn, webrtcCodec)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("Using codec %s (%d) %s", webrtcCodec.Name, webrtcCodec.PayloadType, webrtcCodec.SDPFmtpLine)
+	p.mutex.Lock()
+	p.codec = webrtcCodec.Name
+	p.mutex.Unlock()
+
+	sender, err := addTrack(peerConn, track, getTrackDirection(&sdp, "video"))
+	if err != nil {
+		return "", err
+	}
+
+	if p.audio != nil && p.audioCodec != nil && audioDirection != webrtc.RTPTransceiverDirectionInactive {
+		p.audioTrack, err = p.newTrack(peerConn, p.audioCodec)
+		if err != nil {
+			return "", err
+		}
+		if _, err = addTrack(peerConn, p.audioTrack, audioDirection); err != nil {
+			return "", err
+		}
 	}
 
 	offerSdp := webrtc.SessionDescription{
@@ -162,44 +331,386 @@ func (p *RemoteScreenPeerConn) ProcessOffer(strOffer string) (string, error) {
 		return "", err
 	}
 
-	screen := p.grabber.Screen()
-	sourceSize := image.Point{
-		screen.Bounds.Dx(),
-		screen.Bounds.Dy(),
+	broadcaster, err := p.registry.acquire(p.screen, encCodec, p.options)
+	if err != nil {
+		return "", err
 	}
 
-	encoder, err := p.encService.NewEncoder(encCodec, sourceSize, p.grabber.Fps())
+	p.mutex.Lock()
+	p.streamer = newRTCStreamer(p.track, sender, broadcaster, p.registry, p.options.Bitrate, p.restartBroadcast)
+	p.mutex.Unlock()
+
+	p.mapping = newScreenMapping(p.screen.Bounds, broadcaster.videoSize)
+	p.inputHandler = newInputHandler(p.input, p.SwitchScreen, p.mapping)
+	if p.options.Cursor == CursorChannel {
+		p.cursor = newCursorChannel(p.registry.cursorService, p.mapping, p.options.FPS, p.done)
+	}
+	peerConn.OnDataChannel(func(dc *webrtc.DataChannel) {
+		switch {
+		case dc.Label() == inputChannelLabel && !p.options.ViewOnly:
+			p.inputHandler.attach(dc)
+		case dc.Label() == cursorChannelLabel && p.cursor != nil:
+			p.cursor.attach(dc)
+		default:
+			log.Printf("Ignoring data channel %s", dc.Label())
+		}
+	})
+
+	err = peerConn.SetLocalDescription(answer)
 	if err != nil {
 		return "", err
 	}
+	return answer.SDP, nil
+}
+
+// SetSignaler sets the channel used to send server initiated offers
+func (p *RemoteScreenPeerConn) SetSignaler(signaler Signaler) {
+	p.mutex.Lock()
+	p.signaler = signaler
+	p.mutex.Unlock()
+}
+
+// Renegotiate sends a new offer to the client thru the signaler. pion can't
+// renegotiate an established peer connection, so a new one is negotiated and
+// it replaces the current one once connected, keeping the session, the
+// broadcaster subscription and the input injector
+func (p *RemoteScreenPeerConn) Renegotiate() error {
+	p.mutex.Lock()
+	signaler := p.signaler
+	established := p.connection != nil && p.streamer != nil
+	p.mutex.Unlock()
+	if signaler == nil {
+		return ErrRenegotiationUnsupported
+	}
+	if !established {
+		return fmt.Errorf("The session has no offer yet")
+	}
 
-	size, err := encoder.VideoSize()
+	// The offer carries every candidate, the client can still trickle its own
+	peerConn, err := p.newPeerConnection(false)
 	if err != nil {
-		return "", err
+		return err
+	}
+	track, err := p.newTrack(peerConn, p.webrtcCodec)
+	if err != nil {
+		peerConn.Close()
+		return err
+	}
+	sender, err := addTrack(peerConn, track, webrtc.RTPTransceiverDirectionRecvonly)
+	if err != nil {
+		peerConn.Close()
+		return err
+	}
+	var audioTrack *webrtc.Track
+	if p.audioTrack != nil {
+		audioTrack, err = p.newTrack(peerConn, p.audioCodec)
+		if err == nil {
+			_, err = addTrack(peerConn, audioTrack, webrtc.RTPTransceiverDirectionRecvonly)
+		}
+		if err != nil {
+			peerConn.Close()
+			return err
+		}
+	}
+	if !p.options.ViewOnly {
+		dc, err := peerConn.CreateDataChannel(inputChannelLabel, nil)
+		if err != nil {
+			peerConn.Close()
+			return err
+		}
+		p.inputHandler.attach(dc)
+	}
+	if p.cursor != nil {
+		dc, err := peerConn.CreateDataChannel(cursorChannelLabel, nil)
+		if err != nil {
+			peerConn.Close()
+			return err
+		}
+		p.cursor.attach(dc)
 	}
 
-	p.streamer = newRTCStreamer(p.track, &p.grabber, &encoder, size)
+	offer, err := peerConn.CreateOffer(nil)
+	if err != nil {
+		peerConn.Close()
+		return err
+	}
+	if err := peerConn.SetLocalDescription(offer); err != nil {
+		peerConn.Close()
+		return err
+	}
 
-	err = peerConn.SetLocalDescription(answer)
+	p.mutex.Lock()
+	previous := p.pending
+	p.pending = &pendingConnection{
+		connection: peerConn,
+		track:      track,
+		sender:     sender,
+		audioTrack: audioTrack,
+	}
+	p.mutex.Unlock()
+	if previous != nil {
+		previous.connection.Close()
+	}
+	return signaler.SendOffer(offer.SDP)
+}
+
+// AcceptAnswer completes a renegotiation started with Renegotiate
+func (p *RemoteScreenPeerConn) AcceptAnswer(answer string) error {
+	p.mutex.Lock()
+	pending := p.pending
+	p.mutex.Unlock()
+	if pending == nil {
+		return fmt.Errorf("The session has no pending offer")
+	}
+	return pending.connection.SetRemoteDescription(webrtc.SessionDescription{
+		SDP:  answer,
+		Type: webrtc.SDPTypeAnswer,
+	})
+}
+
+// promotePending moves the stream to the renegotiated peer connection and
+// closes the previous one
+func (p *RemoteScreenPeerConn) promotePending() {
+	p.mutex.Lock()
+	pending := p.pending
+	if pending == nil {
+		p.mutex.Unlock()
+		return
+	}
+	previous := p.connection
+	previousAudio := p.audioTrack
+	p.connection = pending.connection
+	p.track = pending.track
+	p.audioTrack = pending.audioTrack
+	p.pending = nil
+	p.state = webrtc.ICEConnectionStateConnected
+	p.mutex.Unlock()
+
+	p.streamer.replaceTrack(pending.track, pending.sender)
+	if pending.audioTrack != nil {
+		if err := p.audio.subscribe(pending.audioTrack); err != nil {
+			log.Printf("Session %s audio: %v", p.id, err)
+		}
+		p.audio.unsubscribe(previousAudio)
+	}
+	previous.Close()
+}
+
+func (p *RemoteScreenPeerConn) discardPending() {
+	p.mutex.Lock()
+	pending := p.pending
+	p.pending = nil
+	p.mutex.Unlock()
+	if pending != nil {
+		pending.connection.Close()
+	}
+}
+
+// SwitchScreen moves the session to another screen without renegotiating,
+// the encoder is shared with the other viewers of that screen or created
+// for it, and the client gets a keyframe right away
+func (p *RemoteScreenPeerConn) SwitchScreen(screenIx int) error {
+	p.switchMutex.Lock()
+	defer p.switchMutex.Unlock()
+
+	screens, err := p.registry.videoService.Screens()
 	if err != nil {
-		return "", err
+		return err
 	}
-	return answer.SDP, nil
+	if screenIx < 0 || screenIx >= len(screens) {
+		return ErrScreenNotFound
+	}
+	screen := screens[screenIx]
+	if err := p.switchBroadcaster(screen); err != nil {
+		return err
+	}
+	log.Printf("Session %s switched to screen %d", p.id, screen.Index)
+	return nil
+}
+
+// switchBroadcaster moves the stream to the broadcaster of the screen,
+// switchMutex must be held
+func (p *RemoteScreenPeerConn) switchBroadcaster(screen rdisplay.Screen) error {
+	p.mutex.Lock()
+	streamer := p.streamer
+	p.mutex.Unlock()
+	if streamer == nil {
+		return fmt.Errorf("The session has no offer yet")
+	}
+
+	broadcaster, err := p.registry.acquire(screen, p.encCodec, p.options)
+	if err != nil {
+		return err
+	}
+	p.mapping.setTarget(screen.Bounds, broadcaster.videoSize)
+	streamer.switchBroadcaster(broadcaster)
+
+	p.mutex.Lock()
+	p.screen = screen
+	p.mutex.Unlock()
+	return nil
+}
+
+// restartBroadcast moves the stream to a new broadcaster of the same screen
+// after the current one failed. If it fails again right away the session is
+// closed, so the client notices instead of waiting for video
+func (p *RemoteScreenPeerConn) restartBroadcast() {
+	p.switchMutex.Lock()
+	defer p.switchMutex.Unlock()
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+
+	p.mutex.Lock()
+	screen := p.screen
+	recent := time.Since(p.restartedAt) < broadcastRestartInterval
+	p.restartedAt = time.Now()
+	p.mutex.Unlock()
+
+	err := fmt.Errorf("it failed again within %v", broadcastRestartInterval)
+	if !recent {
+		err = p.switchBroadcaster(screen)
+	}
+	if err != nil {
+		log.Printf("Session %s: can't restart the video broadcast, closing it: %v", p.id, err)
+		p.Close()
+		return
+	}
+	log.Printf("Session %s restarted the video broadcast of screen %d", p.id, screen.Index)
+}
+
+// Done returns a channel that's closed when the session ends
+func (p *RemoteScreenPeerConn) Done() <-chan struct{} {
+	return p.done
+}
+
+// AddICECandidate adds a candidate trickled by the client, while renegotiating
+// it belongs to the pending peer connection
+func (p *RemoteScreenPeerConn) AddICECandidate(candidate ICECandidate) error {
+	p.mutex.Lock()
+	peerConn := p.connection
+	if p.pending != nil {
+		peerConn = p.pending.connection
+	}
+	p.mutex.Unlock()
+	if peerConn == nil {
+		return fmt.Errorf("The session has no offer yet")
+	}
+	mid := candidate.SDPMid
+	lineIndex := candidate.SDPMLineIndex
+	return peerConn.AddICECandidate(webrtc.ICECandidateInit{
+		Candidate:     candidate.Candidate,
+		SDPMid:        &mid,
+		SDPMLineIndex: &lineIndex,
+	})
+}
+
+// LocalCandidates returns the candidates gathered since the last call and
+// whether the gathering is complete, waiting up to wait for new candidates
+func (p *RemoteScreenPeerConn) LocalCandidates(wait time.Duration) ([]ICECandidate, bool, error) {
+	if p.candidates == nil {
+		return nil, false, fmt.Errorf("The session doesn't use trickle ICE")
+	}
+	candidates, done := p.candidates.poll(wait)
+	return candidates, done, nil
 }
 
 func (p *RemoteScreenPeerConn) start() {
 	p.streamer.start()
+	if p.audioTrack != nil {
+		if err := p.audio.subscribe(p.audioTrack); err != nil {
+			log.Printf("Session %s audio: %v", p.id, err)
+		}
+	}
+	if p.recordOpts.All {
+		if _, err := p.StartRecording(); err != nil {
+			log.Printf("Session %s can't be recorded: %v", p.id, err)
+		}
+	}
 }
 
-// Close Stops the video streamer and closes the WebRTC peer connection
-func (p *RemoteScreenPeerConn) Close() error {
-
-	if p.streamer != nil {
-		p.streamer.close()
+// StartRecording records the video sent to the client, it returns the
+// path of the recording file
+func (p *RemoteScreenPeerConn) StartRecording() (string, error) {
+	if p.recordOpts.Dir == "" {
+		return "", ErrRecordingDisabled
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.streamer == nil {
+		return "", fmt.Errorf("The session has no offer yet")
 	}
+	if p.recorder != nil {
+		return p.recorder.currentPath(), nil
+	}
+	name := fmt.Sprintf("%s-%s", p.id, time.Now().Format("20060102-150405"))
+	recorder, err := newSessionRecorder(p.recordOpts.Dir, name, p.encCodec, p.streamer.videoSize())
+	if err != nil {
+		return "", err
+	}
+	p.recorder = recorder
+	p.streamer.record(recorder)
+	path := recorder.currentPath()
+	log.Printf("Session %s recording to %s", p.id, path)
+	return path, nil
+}
 
-	if p.connection != nil {
-		return p.connection.Close()
+// StopRecording stops the current recording, if any
+func (p *RemoteScreenPeerConn) StopRecording() error {
+	p.mutex.Lock()
+	recorder := p.recorder
+	p.recorder = nil
+	streamer := p.streamer
+	p.mutex.Unlock()
+	if recorder == nil {
+		return nil
 	}
-	return nil
+	streamer.record(nil)
+	return recorder.close()
+}
+
+// Close Stops the video streamer and closes the WebRTC peer connection,
+// it's safe to call it more than once
+func (p *RemoteScreenPeerConn) Close() error {
+	p.closeOnce.Do(func() {
+		p.connectTimer.Stop()
+
+		if p.streamer != nil {
+			p.streamer.close()
+		}
+		p.StopRecording()
+
+		p.mutex.Lock()
+		audioTrack := p.audioTrack
+		p.mutex.Unlock()
+		if audioTrack != nil {
+			p.audio.unsubscribe(audioTrack)
+		}
+
+		if p.input != nil {
+			p.input.Close()
+		}
+
+		p.mutex.Lock()
+		connection := p.connection
+		pending := p.pending
+		p.pending = nil
+		p.state = webrtc.ICEConnectionStateClosed
+		p.mutex.Unlock()
+
+		if pending != nil {
+			pending.connection.Close()
+		}
+		if connection != nil {
+			p.closeErr = connection.Close()
+		}
+		close(p.done)
+
+		if p.onClose != nil {
+			p.onClose()
+		}
+	})
+	return p.closeErr
 }
@@ -2,8 +2,11 @@ package rtc
 
 import (
 	"fmt"
+	"image"
+	"log"
 
 	"github.com/rviscarra/webrtc-remote-screen/internal/encoders"
+	"github.com/rviscarra/webrtc-remote-screen/internal/raudio"
 	"github.com/rviscarra/webrtc-remote-screen/internal/rdisplay"
 )
 
@@ -11,15 +14,33 @@ import (
 type RemoteScreenService struct {
 	stunServer      string
 	videoService    rdisplay.Service
+	inputService    rdisplay.InputService
 	encodingService encoders.Service
+	limits          StreamLimits
+	recording       RecordingOptions
+	broadcasters    *broadcasterRegistry
+	audio           *audioBroadcaster
+	sessions        *sessionRegistry
 }
 
-// NewRemoteScreenService creates a new instances of RemoteScreenService
-func NewRemoteScreenService(stun string, video rdisplay.Service, enc encoders.Service) Service {
+// NewRemoteScreenService creates a new instances of RemoteScreenService,
+// input can be nil, in which case the sessions are view-only. Without audio
+// (or an Opus encoder) the sessions only have video
+func NewRemoteScreenService(stun string, video rdisplay.Service, input rdisplay.InputService, audio raudio.Service, enc encoders.Service, limits StreamLimits, recording RecordingOptions) Service {
+	var audioBroadcast *audioBroadcaster
+	if audio != nil && enc.SupportsAudio() {
+		audioBroadcast = newAudioBroadcaster(audio, enc)
+	}
 	return &RemoteScreenService{
+		audio:           audioBroadcast,
 		stunServer:      stun,
 		videoService:    video,
+		inputService:    input,
 		encodingService: enc,
+		limits:          limits,
+		recording:       recording,
+		broadcasters:    newBroadcasterRegistry(video, enc, limits.Bitrate, limits.IdleFPS),
+		sessions:        newSessionRegistry(),
 	}
 }
 
@@ -34,29 +55,100 @@ func hasElement(haystack []string, needle string) bool {
 
 // CreateRemoteScreenConnection creates and configures a new peer connection
 // that will stream the selected screen
-func (svc *RemoteScreenService) CreateRemoteScreenConnection(screenIx int, fps int) (RemoteScreenConnection, error) {
+func (svc *RemoteScreenService) CreateRemoteScreenConnection(screenIx int, options StreamOptions, remoteAddr string) (RemoteScreenConnection, error) {
+	options, err := options.normalize(svc.limits)
+	if err != nil {
+		return nil, err
+	}
+	if options.Cursor != CursorNone && svc.broadcasters.cursorService == nil {
+		log.Printf("The video source can't track the cursor, it won't be shown")
+		options.Cursor = CursorNone
+	}
 	screens, err := svc.videoService.Screens()
 	if err != nil {
 		return nil, err
 	}
 
-	if screenIx < 0 || screenIx > len(screens) {
+	if len(screens) == 0 {
+		return nil, fmt.Errorf("No available screens")
+	}
+
+	if screenIx < 0 || screenIx >= len(screens) {
 		screenIx = 0
 	}
 	screen := screens[screenIx]
-	screenGrabber, err := svc.videoService.CreateScreenGrabber(screen, fps)
+
+	var input rdisplay.InputInjector
+	if svc.inputService != nil && !options.ViewOnly {
+		input, err = svc.inputService.CreateInputInjector()
+		if err != nil {
+			log.Printf("Can't create input injector, the session will be view-only: %v", err)
+			input = nil
+		}
+	}
+
+	rtcPeer := newRemoteScreenPeerConn(svc.stunServer, screen, options, svc.recording, svc.broadcasters, svc.audio, svc.encodingService, input, remoteAddr)
+	rtcPeer.onClose = func() {
+		svc.sessions.remove(rtcPeer.id)
+	}
+	svc.sessions.add(rtcPeer)
+	return rtcPeer, nil
+}
+
+// ScreenFrames streams the raw frames of a screen at up to fps, sharing the
+// capture with the WebRTC viewers of the screen when there are any. The
+// stream ends, closing the channel, once stop is closed
+func (svc *RemoteScreenService) ScreenFrames(screenIx int, fps int, stop <-chan struct{}) (<-chan *image.RGBA, error) {
+	options, err := StreamOptions{FPS: fps}.normalize(svc.limits)
 	if err != nil {
 		return nil, err
 	}
-
+	screens, err := svc.videoService.Screens()
 	if err != nil {
 		return nil, err
 	}
+	if screenIx < 0 || screenIx >= len(screens) {
+		return nil, ErrScreenNotFound
+	}
+	stream := &frameStream{
+		screen:   screens[screenIx],
+		fps:      options.FPS,
+		registry: svc.broadcasters,
+		frames:   make(chan *image.RGBA),
+		stop:     stop,
+	}
+	go stream.run()
+	return stream.frames, nil
+}
 
-	if len(screens) == 0 {
-		return nil, fmt.Errorf("No available screens")
+// Sessions returns the running sessions
+func (svc *RemoteScreenService) Sessions() []SessionInfo {
+	return svc.sessions.list()
+}
+
+// Session returns the state of a single session
+func (svc *RemoteScreenService) Session(id string) (SessionInfo, error) {
+	session, err := svc.sessions.get(id)
+	if err != nil {
+		return SessionInfo{}, err
 	}
+	return session.Info(), nil
+}
 
-	rtcPeer := newRemoteScreenPeerConn(svc.stunServer, screenGrabber, svc.encodingService)
-	return rtcPeer, nil
+// Connection returns the connection of a running session
+func (svc *RemoteScreenService) Connection(id string) (RemoteScreenConnection, error) {
+	session, err := svc.sessions.get(id)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// CloseSession terminates a session
+func (svc *RemoteScreenService) CloseSession(id string) error {
+	session, err := svc.sessions.get(id)
+	if err != nil {
+		return err
+	}
+	return session.Close()
 }
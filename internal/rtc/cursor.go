@@ -0,0 +1,199 @@
+package rtc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/png"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v2"
+	"github.com/rviscarra/webrtc-remote-screen/internal/rdisplay"
+)
+
+// Label of the data channel the cursor updates are sent thru
+const cursorChannelLabel = "cursor"
+
+// cursorMessage is sent when the cursor moves ("position") or changes its
+// image ("shape"), everything is expressed in the encoded video space
+type cursorMessage struct {
+	Type    string `json:"type"`
+	X       int    `json:"x"`
+	Y       int    `json:"y"`
+	Visible bool   `json:"visible,omitempty"`
+	Serial  uint32 `json:"serial,omitempty"`
+	// Image is a PNG data URL
+	Image  string `json:"image,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// cursorChannel polls the cursor and sends its updates to the client, the
+// data channel is replaced when the session is renegotiated
+type cursorChannel struct {
+	service  rdisplay.CursorService
+	mapping  *screenMapping
+	interval time.Duration
+	done     <-chan struct{}
+
+	mutex     sync.Mutex
+	channel   *webrtc.DataChannel
+	started   bool
+	sendShape bool
+}
+
+func newCursorChannel(service rdisplay.CursorService, mapping *screenMapping, fps int, done <-chan struct{}) *cursorChannel {
+	return &cursorChannel{
+		service:  service,
+		mapping:  mapping,
+		interval: time.Second / time.Duration(fps),
+		done:     done,
+	}
+}
+
+// attach starts sending the updates thru the data channel once it opens,
+// the polling runs until the session ends
+func (c *cursorChannel) attach(dc *webrtc.DataChannel) {
+	dc.OnOpen(func() {
+		c.mutex.Lock()
+		c.channel = dc
+		// A new client doesn't have the current shape
+		c.sendShape = true
+		start := !c.started
+		c.started = true
+		c.mutex.Unlock()
+		if start {
+			go c.run()
+		}
+	})
+}
+
+// The tracker is created again after an error, waiting from
+// cursorRetryMin to cursorRetryMax as the errors repeat
+const (
+	cursorRetryMin = 500 * time.Millisecond
+	cursorRetryMax = 30 * time.Second
+)
+
+func (c *cursorChannel) run() {
+	var tracker rdisplay.CursorTracker
+	defer func() {
+		if tracker != nil {
+			tracker.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	var last cursorMessage
+	var serial uint32
+	var videoSize image.Point
+	retryDelay := cursorRetryMin
+	var retryAt time.Time
+	fail := func(err error) {
+		log.Printf("Cursor: %v, retrying in %v", err, retryDelay)
+		if tracker != nil {
+			tracker.Close()
+			tracker = nil
+		}
+		retryAt = time.Now().Add(retryDelay)
+		retryDelay *= 2
+		if retryDelay > cursorRetryMax {
+			retryDelay = cursorRetryMax
+		}
+		// The client gets the whole state once the tracker is back
+		last, videoSize = cursorMessage{}, image.Point{}
+	}
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		if tracker == nil {
+			if time.Now().Before(retryAt) {
+				continue
+			}
+			var err error
+			if tracker, err = c.service.CreateCursorTracker(); err != nil {
+				tracker = nil
+				fail(err)
+				continue
+			}
+		}
+		cursor, err := tracker.Cursor()
+		if err != nil {
+			fail(err)
+			continue
+		}
+		retryDelay = cursorRetryMin
+		c.mutex.Lock()
+		channel, sendShape := c.channel, c.sendShape
+		c.sendShape = false
+		c.mutex.Unlock()
+
+		bounds, size := c.mapping.target()
+		// Switching screens may change the scale of the shape
+		if sendShape || cursor.Serial != serial || size != videoSize {
+			shape, err := c.shape(cursor, bounds, size)
+			if err != nil {
+				log.Printf("Cursor: %v", err)
+				continue
+			}
+			c.send(channel, shape)
+			serial, videoSize = cursor.Serial, size
+			sendShape = true
+		}
+		position, visible := c.mapping.toVideo(cursor.Position)
+		msg := cursorMessage{Type: "position", X: position.X, Y: position.Y, Visible: visible}
+		if sendShape || msg != last {
+			c.send(channel, &msg)
+			last = msg
+		}
+	}
+}
+
+// shape scales the cursor image to the video, with its hotspot as position
+func (c *cursorChannel) shape(cursor *rdisplay.Cursor, bounds image.Rectangle, videoSize image.Point) (*cursorMessage, error) {
+	img := cursor.Image
+	hotspot := cursor.Hotspot
+	if bounds.Dx() > 0 && videoSize.X != bounds.Dx() {
+		size := img.Rect.Size()
+		scaled := image.Point{
+			clamp(size.X*videoSize.X/bounds.Dx(), 1, size.X),
+			clamp(size.Y*videoSize.Y/bounds.Dy(), 1, size.Y),
+		}
+		if size.X > 0 && size.Y > 0 {
+			img = resizeImage(img, scaled)
+			hotspot = image.Point{hotspot.X * scaled.X / size.X, hotspot.Y * scaled.Y / size.Y}
+		}
+	}
+	buffer := bytes.Buffer{}
+	if err := png.Encode(&buffer, img); err != nil {
+		return nil, err
+	}
+	return &cursorMessage{
+		Type:   "shape",
+		X:      hotspot.X,
+		Y:      hotspot.Y,
+		Serial: cursor.Serial,
+		Image:  "data:image/png;base64," + base64.StdEncoding.EncodeToString(buffer.Bytes()),
+		Width:  img.Rect.Dx(),
+		Height: img.Rect.Dy(),
+	}, nil
+}
+
+func (c *cursorChannel) send(channel *webrtc.DataChannel, msg *cursorMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Cursor: %v", err)
+		return
+	}
+	// The channel may be closing while the session is renegotiated
+	if err := channel.SendText(string(data)); err != nil {
+		log.Printf("Cursor: can't send update: %v", err)
+	}
+}
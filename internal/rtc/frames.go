@@ -0,0 +1,103 @@
+package rtc
+
+import (
+	"image"
+	"time"
+
+	"github.com/rviscarra/webrtc-remote-screen/internal/rdisplay"
+)
+
+// frameStream forwards raw frames of a screen at up to fps, taking them
+// from a WebRTC broadcaster of the same screen when there is one so the
+// screen isn't captured twice
+type frameStream struct {
+	screen   rdisplay.Screen
+	fps      int
+	registry *broadcasterRegistry
+	frames   chan *image.RGBA
+	stop     <-chan struct{}
+	last     time.Time
+}
+
+// forward sends the frame unless it comes too early for the frame rate,
+// it returns false once the stream is stopped
+func (s *frameStream) forward(frame *image.RGBA) bool {
+	now := time.Now()
+	if now.Sub(s.last) < time.Second/time.Duration(s.fps) {
+		return true
+	}
+	s.last = now
+	select {
+	case s.frames <- frame:
+		return true
+	case <-s.stop:
+		return false
+	}
+}
+
+// fromBroadcaster forwards the frames of a running broadcaster, it returns
+// false if there's none or the stream was stopped while using it
+func (s *frameStream) fromBroadcaster() (bool, bool) {
+	broadcaster, tap := s.registry.tap(s.screen.Index)
+	if tap == nil {
+		return false, true
+	}
+	defer broadcaster.removeTap(tap)
+	for {
+		select {
+		case frame, ok := <-tap:
+			if !ok {
+				return true, true
+			}
+			if !s.forward(frame) {
+				return true, false
+			}
+		case <-s.stop:
+			return true, false
+		}
+	}
+}
+
+// fromGrabber captures the screen itself, until a broadcaster for it
+// shows up or the stream is stopped
+func (s *frameStream) fromGrabber() (bool, error) {
+	grabber, err := s.registry.videoService.CreateScreenGrabber(s.screen, s.fps)
+	if err != nil {
+		return false, err
+	}
+	grabber.Start()
+	defer grabber.Stop()
+	for {
+		select {
+		case frame, ok := <-grabber.Frames():
+			if !ok {
+				return false, nil
+			}
+			if !s.forward(cloneFrame(frame)) {
+				return false, nil
+			}
+			if s.registry.hasBroadcaster(s.screen.Index) {
+				return true, nil
+			}
+		case <-s.stop:
+			return false, nil
+		}
+	}
+}
+
+func (s *frameStream) run() {
+	defer close(s.frames)
+	for {
+		shared, running := s.fromBroadcaster()
+		if !running {
+			return
+		}
+		if shared {
+			continue
+		}
+		switchToShared, err := s.fromGrabber()
+		if err != nil || !switchToShared {
+			return
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package rtc
+
+import (
+	"image"
+	"sync"
+	"time"
+)
+
+// FrameStats counts what happened to the captured frames of a broadcast,
+// shared by every viewer of it
+type FrameStats struct {
+	Captured uint64
+	// Dropped were replaced by a newer frame before the encoder got to them
+	Dropped uint64
+	// Unchanged weren't encoded since they were the same as the previous one
+	Unchanged uint64
+	Encoded   uint64
+	// Latency is the average time from capture until the frame is sent
+	Latency time.Duration
+}
+
+// frameSlot hands frames from the capture loop to the encoder, keeping only
+// the latest one: if the encoder falls behind the older frames are dropped
+// instead of queued, so the latency stays bounded. The frames are copied
+// into buffers owned by the slot since grabbers may reuse theirs
+type frameSlot struct {
+	mutex      sync.Mutex
+	free       []*image.RGBA
+	pending    *image.RGBA
+	capturedAt time.Time
+	inUse      *image.RGBA
+	closed     bool
+	ready      chan struct{}
+}
+
+func newFrameSlot() *frameSlot {
+	return &frameSlot{ready: make(chan struct{}, 1)}
+}
+
+// buffer returns a free buffer for a frame of the given bounds
+func (s *frameSlot) buffer(bounds image.Rectangle) *image.RGBA {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for len(s.free) > 0 {
+		buffer := s.free[len(s.free)-1]
+		s.free = s.free[:len(s.free)-1]
+		if buffer.Rect == bounds {
+			return buffer
+		}
+	}
+	return image.NewRGBA(bounds)
+}
+
+// put replaces the pending frame, it returns true if one was dropped
+func (s *frameSlot) put(frame *image.RGBA, capturedAt time.Time) bool {
+	buffer := s.buffer(frame.Rect)
+	copy(buffer.Pix, frame.Pix)
+
+	s.mutex.Lock()
+	dropped := s.pending != nil
+	if dropped {
+		s.free = append(s.free, s.pending)
+	}
+	s.pending, s.capturedAt = buffer, capturedAt
+	s.mutex.Unlock()
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return dropped
+}
+
+// take waits for the next frame, which is valid until the following call.
+// It returns false once the slot is closed
+func (s *frameSlot) take() (*image.RGBA, time.Time, bool) {
+	for range s.ready {
+		s.mutex.Lock()
+		frame, capturedAt := s.pending, s.capturedAt
+		if frame != nil {
+			if s.inUse != nil {
+				s.free = append(s.free, s.inUse)
+			}
+			s.inUse, s.pending = frame, nil
+		}
+		closed := s.closed
+		s.mutex.Unlock()
+		if closed {
+			return nil, time.Time{}, false
+		}
+		if frame != nil {
+			return frame, capturedAt, true
+		}
+	}
+	return nil, time.Time{}, false
+}
+
+// close wakes up take, the pending frame is discarded
+func (s *frameSlot) close() {
+	s.mutex.Lock()
+	s.closed = true
+	s.mutex.Unlock()
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
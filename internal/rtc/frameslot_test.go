@@ -0,0 +1,100 @@
+package rtc
+
+import (
+	"image"
+	"testing"
+	"time"
+)
+
+func filledFrame(bounds image.Rectangle, value byte) *image.RGBA {
+	frame := image.NewRGBA(bounds)
+	for i := range frame.Pix {
+		frame.Pix[i] = value
+	}
+	return frame
+}
+
+func isFilled(frame *image.RGBA, value byte) bool {
+	for _, v := range frame.Pix {
+		if v != value {
+			return false
+		}
+	}
+	return true
+}
+
+var slotBounds = image.Rect(0, 0, 4, 4)
+
+func TestFrameSlotKeepsLatest(t *testing.T) {
+	slot := newFrameSlot()
+	start := time.Now()
+	if slot.put(filledFrame(slotBounds, 1), start) {
+		t.Error("The first frame dropped another one")
+	}
+	if !slot.put(filledFrame(slotBounds, 2), start.Add(time.Second)) {
+		t.Error("Replacing a pending frame didn't report a drop")
+	}
+	frame, capturedAt, ok := slot.take()
+	if !ok || !isFilled(frame, 2) || !capturedAt.Equal(start.Add(time.Second)) {
+		t.Errorf("Took %v captured at %v, expected the second frame", frame.Pix[0], capturedAt)
+	}
+}
+
+func TestFrameSlotCopiesFrames(t *testing.T) {
+	slot := newFrameSlot()
+	// Grabbers reuse their buffers
+	source := filledFrame(slotBounds, 1)
+	slot.put(source, time.Now())
+	taken, _, _ := slot.take()
+	source.Pix[0] = 9
+	if !isFilled(taken, 1) {
+		t.Error("The frame changed along with the grabber buffer")
+	}
+
+	// The taken frame stays valid while the next ones arrive
+	slot.put(filledFrame(slotBounds, 2), time.Now())
+	slot.put(filledFrame(slotBounds, 3), time.Now())
+	if !isFilled(taken, 1) {
+		t.Error("The frame in use was overwritten")
+	}
+	next, _, _ := slot.take()
+	if next == taken || !isFilled(next, 3) {
+		t.Error("Took the wrong frame after the one in use")
+	}
+}
+
+func TestFrameSlotSizeChange(t *testing.T) {
+	slot := newFrameSlot()
+	slot.put(filledFrame(slotBounds, 1), time.Now())
+	slot.take()
+	larger := image.Rect(0, 0, 8, 2)
+	slot.put(filledFrame(slotBounds, 2), time.Now())
+	slot.put(filledFrame(larger, 3), time.Now())
+	frame, _, _ := slot.take()
+	if frame.Rect != larger || !isFilled(frame, 3) {
+		t.Errorf("Took a %v frame, expected %v", frame.Rect, larger)
+	}
+}
+
+func TestFrameSlotClose(t *testing.T) {
+	slot := newFrameSlot()
+	done := make(chan bool)
+	go func() {
+		_, _, ok := slot.take()
+		done <- ok
+	}()
+	slot.close()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("take returned a frame after close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("close didn't wake up take")
+	}
+	// The pending frame is discarded
+	slot.put(filledFrame(slotBounds, 1), time.Now())
+	if _, _, ok := slot.take(); ok {
+		t.Error("take returned a frame from a closed slot")
+	}
+}
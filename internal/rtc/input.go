@@ -0,0 +1,148 @@
+package rtc
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"log"
+	"sync"
+
+	"github.com/pion/webrtc/v2"
+	"github.com/rviscarra/webrtc-remote-screen/internal/rdisplay"
+)
+
+// Label of the data channel the client creates to send input events
+const inputChannelLabel = "input"
+
+// inputEvent is the payload of each data channel message, coordinates
+// are expressed in the encoded video space (see encoders.Encoder.VideoSize)
+type inputEvent struct {
+	Type   string `json:"type"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Button int    `json:"button"`
+	DeltaX int    `json:"dx"`
+	DeltaY int    `json:"dy"`
+	Key    string `json:"key"`
+	Screen int    `json:"screen"`
+}
+
+// screenMapping maps the encoded video to the captured screen, it's
+// updated when the session switches screens
+type screenMapping struct {
+	mutex     sync.Mutex
+	bounds    image.Rectangle
+	videoSize image.Point
+}
+
+func newScreenMapping(bounds image.Rectangle, videoSize image.Point) *screenMapping {
+	return &screenMapping{bounds: bounds, videoSize: videoSize}
+}
+
+// setTarget updates the screen and video size
+func (m *screenMapping) setTarget(bounds image.Rectangle, videoSize image.Point) {
+	m.mutex.Lock()
+	m.bounds = bounds
+	m.videoSize = videoSize
+	m.mutex.Unlock()
+}
+
+func (m *screenMapping) target() (image.Rectangle, image.Point) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.bounds, m.videoSize
+}
+
+func clamp(value, min, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
+
+// toScreen maps a point in the encoded video to the captured screen
+func (m *screenMapping) toScreen(x, y int) (int, int) {
+	bounds, videoSize := m.target()
+	if videoSize.X <= 0 || videoSize.Y <= 0 {
+		return bounds.Min.X, bounds.Min.Y
+	}
+	screenX := bounds.Min.X + x*bounds.Dx()/videoSize.X
+	screenY := bounds.Min.Y + y*bounds.Dy()/videoSize.Y
+	return clamp(screenX, bounds.Min.X, bounds.Max.X-1),
+		clamp(screenY, bounds.Min.Y, bounds.Max.Y-1)
+}
+
+// toVideo maps a point of the desktop to the encoded video, the point may
+// fall outside of it
+func (m *screenMapping) toVideo(point image.Point) (image.Point, bool) {
+	bounds, videoSize := m.target()
+	if bounds.Dx() <= 0 || bounds.Dy() <= 0 {
+		return image.Point{}, false
+	}
+	offset := point.Sub(bounds.Min)
+	return image.Point{
+		offset.X * videoSize.X / bounds.Dx(),
+		offset.Y * videoSize.Y / bounds.Dy(),
+	}, point.In(bounds)
+}
+
+// inputHandler injects the events received thru the data channel, without
+// an injector the session is view-only and only control messages (switching
+// the captured screen) are handled
+type inputHandler struct {
+	injector     rdisplay.InputInjector
+	switchScreen func(screenIx int) error
+	mapping      *screenMapping
+}
+
+func newInputHandler(injector rdisplay.InputInjector, switchScreen func(int) error, mapping *screenMapping) *inputHandler {
+	return &inputHandler{
+		injector:     injector,
+		switchScreen: switchScreen,
+		mapping:      mapping,
+	}
+}
+
+func (h *inputHandler) toScreen(x, y int) (int, int) {
+	return h.mapping.toScreen(x, y)
+}
+
+func (h *inputHandler) handle(evt *inputEvent) error {
+	if evt.Type == "screen" {
+		return h.switchScreen(evt.Screen)
+	}
+	if h.injector == nil {
+		return nil
+	}
+	switch evt.Type {
+	case "mousemove":
+		return h.injector.MouseMove(h.toScreen(evt.X, evt.Y))
+	case "mousedown", "mouseup":
+		if err := h.injector.MouseMove(h.toScreen(evt.X, evt.Y)); err != nil {
+			return err
+		}
+		return h.injector.MouseButton(evt.Button, evt.Type == "mousedown")
+	case "wheel":
+		return h.injector.MouseWheel(evt.DeltaX, evt.DeltaY)
+	case "keydown", "keyup":
+		return h.injector.Key(evt.Key, evt.Type == "keydown")
+	}
+	return fmt.Errorf("Unknown input event type %q", evt.Type)
+}
+
+// attach starts processing the messages received thru the data channel
+func (h *inputHandler) attach(dc *webrtc.DataChannel) {
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		evt := inputEvent{}
+		if err := json.Unmarshal(msg.Data, &evt); err != nil {
+			log.Printf("Input: invalid message: %v", err)
+			return
+		}
+		if err := h.handle(&evt); err != nil {
+			log.Printf("Input: %v", err)
+		}
+	})
+}
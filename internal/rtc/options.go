@@ -0,0 +1,109 @@
+package rtc
+
+import (
+	"errors"
+	"image"
+)
+
+// Frame rate used when the client doesn't ask for one
+const defaultFPS = 20
+
+// ErrInvalidStreamOptions is returned when the options requested by the
+// client are out of the server limits
+var ErrInvalidStreamOptions = errors.New("Invalid stream options")
+
+// ErrScreenNotFound is returned when the screen index doesn't exist
+var ErrScreenNotFound = errors.New("Screen not found")
+
+// CursorMode selects how the remote cursor is shown to a client
+type CursorMode string
+
+const (
+	// CursorNone doesn't show the cursor
+	CursorNone CursorMode = "none"
+	// CursorComposite draws the cursor into the video frames
+	CursorComposite CursorMode = "composite"
+	// CursorChannel sends the cursor position and shape thru the "cursor"
+	// data channel so the client can draw it
+	CursorChannel CursorMode = "channel"
+)
+
+// StreamLimits bounds the stream options the clients can request
+type StreamLimits struct {
+	MaxFPS int
+	// IdleFPS is the frame rate while the screen doesn't change, the
+	// unchanged frames are captured but not encoded. Zero disables it
+	IdleFPS int
+	Bitrate BitrateLimits
+	// Codecs is the video codec preference order, the ones left out are
+	// never used. Empty selects DefaultCodecs
+	Codecs []string
+}
+
+// StreamOptions are the video settings requested by a client, zero
+// values select the server defaults
+type StreamOptions struct {
+	FPS int
+	// MaxWidth and MaxHeight bound the encoded video size, the screen
+	// aspect ratio is kept
+	MaxWidth  int
+	MaxHeight int
+	// Bitrate caps the bitrate sent to this client, in bits per second
+	Bitrate int
+	Cursor  CursorMode
+	// Chroma444 asks for 4:4:4 video, which keeps colored text sharp. It
+	// needs the VP9 encoder and a client that decodes VP9 profile 1,
+	// otherwise the video is 4:2:0
+	Chroma444 bool
+	// Codecs narrows and reorders the codecs allowed by the server, once
+	// normalized it's the preference order of the session
+	Codecs []string
+	// ViewOnly sessions don't get remote input, their input data channel
+	// is ignored. It's set by the server, not requested by the client
+	ViewOnly bool
+}
+
+// normalize validates the options against the limits and fills in the defaults
+func (o StreamOptions) normalize(limits StreamLimits) (StreamOptions, error) {
+	if o.FPS < 0 || o.FPS > limits.MaxFPS || o.MaxWidth < 0 || o.MaxHeight < 0 || o.Bitrate < 0 {
+		return o, ErrInvalidStreamOptions
+	}
+	if o.FPS == 0 {
+		o.FPS = defaultFPS
+		if o.FPS > limits.MaxFPS {
+			o.FPS = limits.MaxFPS
+		}
+	}
+	if o.Bitrate > 0 {
+		o.Bitrate = limits.Bitrate.clamp(o.Bitrate)
+	}
+	switch o.Cursor {
+	case "":
+		o.Cursor = CursorNone
+	case CursorNone, CursorComposite, CursorChannel:
+	default:
+		return o, ErrInvalidStreamOptions
+	}
+	codecs, err := codecOrder(limits.Codecs, o.Codecs)
+	if err != nil {
+		return o, err
+	}
+	o.Codecs = codecs
+	return o, nil
+}
+
+// videoSize returns the largest size that fits the options keeping the
+// aspect ratio of the screen, encoders need even dimensions
+func (o StreamOptions) videoSize(screenSize image.Point) image.Point {
+	size := screenSize
+	if o.MaxWidth > 0 && size.X > o.MaxWidth {
+		size = image.Point{o.MaxWidth, size.Y * o.MaxWidth / size.X}
+	}
+	if o.MaxHeight > 0 && size.Y > o.MaxHeight {
+		size = image.Point{size.X * o.MaxHeight / size.Y, o.MaxHeight}
+	}
+	if size == screenSize {
+		return size
+	}
+	return image.Point{clamp(size.X&^1, 2, screenSize.X), clamp(size.Y&^1, 2, screenSize.Y)}
+}
@@ -0,0 +1,156 @@
+package rtc
+
+import (
+	"log"
+	"sync"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v2"
+	"github.com/rviscarra/webrtc-remote-screen/internal/encoders"
+)
+
+// BitrateLimits bounds the encoder target bitrate, in bits per second
+type BitrateLimits struct {
+	Min int
+	Max int
+}
+
+func (l BitrateLimits) clamp(bitrate int) int {
+	return clamp(bitrate, l.Min, l.Max)
+}
+
+// Loss based control, taken from the sender side of Google Congestion Control:
+// under 2% of loss the estimate grows, over 10% it decreases proportionally
+const (
+	lowLossThreshold      = 0.02
+	highLossThreshold     = 0.10
+	bitrateIncreaseFactor = 1.05
+	// Changes smaller than this are not worth reconfiguring the encoder
+	minBitrateChange = 0.03
+)
+
+// viewerEstimate keeps the feedback received from a single viewer,
+// max is the bitrate the viewer asked for (zero if it didn't)
+type viewerEstimate struct {
+	remb      int
+	lossBased int
+	max       int
+}
+
+func (v *viewerEstimate) bitrate() int {
+	bitrate := v.lossBased
+	if v.remb > 0 && v.remb < bitrate {
+		bitrate = v.remb
+	}
+	if v.max > 0 && v.max < bitrate {
+		bitrate = v.max
+	}
+	return bitrate
+}
+
+// rateController drives the bitrate of a shared encoder, the target is
+// the lowest estimate among its viewers so nobody gets congested
+type rateController struct {
+	mutex     sync.Mutex
+	limits    BitrateLimits
+	encoder   encoders.Encoder
+	estimates map[*webrtc.Track]*viewerEstimate
+	current   int
+}
+
+// initialBitrate returns the bitrate a new encoder starts with, the
+// client hint if any or the middle of the range
+func initialBitrate(limits BitrateLimits, hint int) int {
+	if hint > 0 {
+		return limits.clamp(hint)
+	}
+	return limits.clamp((limits.Min + limits.Max) / 2)
+}
+
+func newRateController(limits BitrateLimits, encoder encoders.Encoder, initial int) *rateController {
+	rc := &rateController{
+		limits:    limits,
+		encoder:   encoder,
+		estimates: make(map[*webrtc.Track]*viewerEstimate),
+		current:   initial,
+	}
+	if err := encoder.SetBitrate(rc.current); err != nil {
+		log.Printf("Rate control: %v", err)
+	}
+	return rc
+}
+
+func (rc *rateController) addViewer(track *webrtc.Track, maxBitrate int) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	rc.estimates[track] = &viewerEstimate{
+		lossBased: rc.current,
+		max:       maxBitrate,
+	}
+	rc.update()
+}
+
+func (rc *rateController) removeViewer(track *webrtc.Track) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	delete(rc.estimates, track)
+	rc.update()
+}
+
+// onFeedback updates the viewer estimate from a REMB or receiver report
+func (rc *rateController) onFeedback(track *webrtc.Track, packet rtcp.Packet) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	estimate, found := rc.estimates[track]
+	if !found {
+		return
+	}
+	switch p := packet.(type) {
+	case *rtcp.ReceiverEstimatedMaximumBitrate:
+		estimate.remb = int(p.Bitrate)
+	case *rtcp.ReceiverReport:
+		for _, report := range p.Reports {
+			if report.SSRC != track.SSRC() {
+				continue
+			}
+			loss := float64(report.FractionLost) / 256.0
+			if loss < lowLossThreshold {
+				estimate.lossBased = int(float64(estimate.lossBased) * bitrateIncreaseFactor)
+			} else if loss > highLossThreshold {
+				estimate.lossBased = int(float64(estimate.lossBased) * (1 - 0.5*loss))
+			}
+			estimate.lossBased = rc.limits.clamp(estimate.lossBased)
+		}
+	default:
+		return
+	}
+	rc.update()
+}
+
+// update reconfigures the encoder, must be called with the mutex held
+func (rc *rateController) update() {
+	if len(rc.estimates) == 0 {
+		return
+	}
+	target := rc.limits.Max
+	for _, estimate := range rc.estimates {
+		if bitrate := estimate.bitrate(); bitrate < target {
+			target = bitrate
+		}
+	}
+	target = rc.limits.clamp(target)
+	// Small changes are skipped unless they reach a limit, otherwise
+	// the last step towards it would never be taken
+	atLimit := target == rc.limits.Min || target == rc.limits.Max
+	if rc.current > 0 && !(atLimit && target != rc.current) {
+		change := float64(target-rc.current) / float64(rc.current)
+		if change > -minBitrateChange && change < minBitrateChange {
+			return
+		}
+	}
+	if err := rc.encoder.SetBitrate(target); err != nil {
+		log.Printf("Rate control: %v", err)
+		return
+	}
+	rc.current = target
+}
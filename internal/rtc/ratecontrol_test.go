@@ -0,0 +1,158 @@
+package rtc
+
+import (
+	"image"
+	"testing"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v2"
+)
+
+// bitrateEncoder only records the bitrates it's configured with
+type bitrateEncoder struct {
+	bitrates []int
+}
+
+func (e *bitrateEncoder) Encode(*image.RGBA) ([]byte, error) { return nil, nil }
+func (e *bitrateEncoder) VideoSize() (image.Point, error)    { return image.Point{}, nil }
+func (e *bitrateEncoder) RequestKeyFrame()                   {}
+func (e *bitrateEncoder) Close() error                       { return nil }
+func (e *bitrateEncoder) SetBitrate(bitrate int) error {
+	e.bitrates = append(e.bitrates, bitrate)
+	return nil
+}
+
+func (e *bitrateEncoder) last() int {
+	if len(e.bitrates) == 0 {
+		return 0
+	}
+	return e.bitrates[len(e.bitrates)-1]
+}
+
+var testLimits = BitrateLimits{Min: 100000, Max: 2000000}
+
+func newTestTrack(t *testing.T, ssrc uint32) *webrtc.Track {
+	t.Helper()
+	codec := webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000)
+	track, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeVP8, ssrc, "video", "test", codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return track
+}
+
+func lossReport(track *webrtc.Track, loss float64) *rtcp.ReceiverReport {
+	return &rtcp.ReceiverReport{
+		Reports: []rtcp.ReceptionReport{{SSRC: track.SSRC(), FractionLost: uint8(loss * 256)}},
+	}
+}
+
+func TestInitialBitrate(t *testing.T) {
+	tests := []struct {
+		hint     int
+		expected int
+	}{
+		{0, 1050000},
+		{500000, 500000},
+		{50000, testLimits.Min},
+		{5000000, testLimits.Max},
+	}
+	for _, test := range tests {
+		if bitrate := initialBitrate(testLimits, test.hint); bitrate != test.expected {
+			t.Errorf("initialBitrate(%d) = %d, expected %d", test.hint, bitrate, test.expected)
+		}
+	}
+}
+
+func TestRateControllerLoss(t *testing.T) {
+	tests := []struct {
+		name     string
+		loss     float64
+		expected int
+	}{
+		{"no loss", 0, 1050000},
+		{"low loss", 0.05, 1000000},
+		{"high loss", 0.25, 875000},
+	}
+	for _, test := range tests {
+		encoder := &bitrateEncoder{}
+		rc := newRateController(testLimits, encoder, 1000000)
+		track := newTestTrack(t, 1)
+		rc.addViewer(track, 0)
+		rc.onFeedback(track, lossReport(track, test.loss))
+		if bitrate := encoder.last(); bitrate != test.expected {
+			t.Errorf("%s: bitrate is %d, expected %d", test.name, bitrate, test.expected)
+		}
+	}
+}
+
+func TestRateControllerLimits(t *testing.T) {
+	encoder := &bitrateEncoder{}
+	rc := newRateController(testLimits, encoder, testLimits.Min)
+	track := newTestTrack(t, 1)
+	rc.addViewer(track, 0)
+	for i := 0; i < 10; i++ {
+		rc.onFeedback(track, lossReport(track, 0.5))
+	}
+	if bitrate := encoder.last(); bitrate != testLimits.Min {
+		t.Errorf("Bitrate is %d under heavy loss, expected the minimum %d", bitrate, testLimits.Min)
+	}
+	for i := 0; i < 100; i++ {
+		rc.onFeedback(track, lossReport(track, 0))
+	}
+	if bitrate := encoder.last(); bitrate != testLimits.Max {
+		t.Errorf("Bitrate is %d without loss, expected the maximum %d", bitrate, testLimits.Max)
+	}
+}
+
+func TestRateControllerLowestViewer(t *testing.T) {
+	encoder := &bitrateEncoder{}
+	rc := newRateController(testLimits, encoder, 1000000)
+	fast, slow := newTestTrack(t, 1), newTestTrack(t, 2)
+	rc.addViewer(fast, 0)
+	rc.addViewer(slow, 0)
+
+	rc.onFeedback(slow, &rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: 300000})
+	if bitrate := encoder.last(); bitrate != 300000 {
+		t.Errorf("Bitrate is %d, expected the slow viewer REMB", bitrate)
+	}
+	// The other viewer reports aren't about this track
+	rc.onFeedback(fast, lossReport(slow, 0.5))
+	if bitrate := encoder.last(); bitrate != 300000 {
+		t.Errorf("Bitrate is %d after a report of another SSRC", bitrate)
+	}
+	rc.removeViewer(slow)
+	if bitrate := encoder.last(); bitrate != 1000000 {
+		t.Errorf("Bitrate is %d after the slow viewer left, expected 1000000", bitrate)
+	}
+}
+
+func TestRateControllerViewerMax(t *testing.T) {
+	encoder := &bitrateEncoder{}
+	rc := newRateController(testLimits, encoder, 1000000)
+	track := newTestTrack(t, 1)
+	rc.addViewer(track, 400000)
+	if bitrate := encoder.last(); bitrate != 400000 {
+		t.Errorf("Bitrate is %d, expected the viewer maximum", bitrate)
+	}
+	rc.onFeedback(track, &rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: 1500000})
+	if bitrate := encoder.last(); bitrate != 400000 {
+		t.Errorf("Bitrate is %d, a REMB can't raise it above the viewer maximum", bitrate)
+	}
+}
+
+func TestRateControllerSmallChanges(t *testing.T) {
+	encoder := &bitrateEncoder{}
+	rc := newRateController(testLimits, encoder, 1000000)
+	track := newTestTrack(t, 1)
+	rc.addViewer(track, 0)
+	calls := len(encoder.bitrates)
+	rc.onFeedback(track, &rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: 990000})
+	if len(encoder.bitrates) != calls {
+		t.Errorf("A 1%% change reconfigured the encoder to %d", encoder.last())
+	}
+	rc.onFeedback(track, &rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: 900000})
+	if bitrate := encoder.last(); bitrate != 900000 {
+		t.Errorf("Bitrate is %d, expected 900000", bitrate)
+	}
+}
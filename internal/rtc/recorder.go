@@ -0,0 +1,120 @@
+package rtc
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/rviscarra/webrtc-remote-screen/internal/encoders"
+	"github.com/rviscarra/webrtc-remote-screen/internal/recording"
+)
+
+// ErrRecordingDisabled is returned when no recordings directory is configured
+var ErrRecordingDisabled = errors.New("Recording is disabled")
+
+// RecordingOptions configure the session recordings, with an empty Dir
+// sessions can't be recorded
+type RecordingOptions struct {
+	Dir string
+	// All records every session from the moment it connects
+	All bool
+}
+
+// sessionRecorder writes the samples a session receives to a file, starting
+// at the first keyframe so the recording can be decoded. The containers
+// can't change the video size mid-stream, the recording continues in a new
+// file (a new part) when it does
+type sessionRecorder struct {
+	mutex     sync.Mutex
+	dir       string
+	name      string
+	codec     encoders.VideoCodec
+	writer    recording.Writer
+	path      string
+	size      image.Point
+	part      int
+	startedAt time.Time
+	keyFrame  bool
+	closed    bool
+}
+
+func newSessionRecorder(dir, name string, codec encoders.VideoCodec, size image.Point) (*sessionRecorder, error) {
+	r := &sessionRecorder{
+		dir:   dir,
+		name:  name,
+		codec: codec,
+	}
+	if err := r.nextPart(size); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// nextPart creates the file of the next part, the first one is named
+// after the recording and the next ones get a -2, -3... suffix
+func (r *sessionRecorder) nextPart(size image.Point) error {
+	name := r.name
+	if r.part > 0 {
+		name = fmt.Sprintf("%s-%d", r.name, r.part+1)
+	}
+	writer, path, err := recording.Create(r.dir, name, r.codec, size)
+	if err != nil {
+		return err
+	}
+	r.writer, r.path, r.size = writer, path, size
+	r.part++
+	r.keyFrame = false
+	return nil
+}
+
+// write appends an encoded frame of the given size
+func (r *sessionRecorder) write(payload []byte, size image.Point) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return
+	}
+	if size != r.size {
+		if err := r.writer.Close(); err != nil {
+			log.Printf("Recorder: can't close %s: %v", r.path, err)
+		}
+		if err := r.nextPart(size); err != nil {
+			log.Printf("Recorder: can't continue %s at %v, stopping: %v", r.path, size, err)
+			r.closed = true
+			return
+		}
+		log.Printf("Recorder: the video size changed to %v, continuing in %s", size, r.path)
+	}
+	if !r.keyFrame {
+		if !recording.IsKeyFrame(r.codec, payload) {
+			return
+		}
+		r.keyFrame = true
+		r.startedAt = time.Now()
+	}
+	if err := r.writer.WriteFrame(payload, time.Since(r.startedAt)); err != nil {
+		log.Printf("Recorder: can't write to %s, stopping: %v", r.path, err)
+		r.closed = true
+		r.writer.Close()
+	}
+}
+
+// currentPath returns the path of the file being written
+func (r *sessionRecorder) currentPath() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.path
+}
+
+func (r *sessionRecorder) close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return r.writer.Close()
+}
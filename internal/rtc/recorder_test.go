@@ -0,0 +1,64 @@
+package rtc
+
+import (
+	"encoding/binary"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rviscarra/webrtc-remote-screen/internal/encoders"
+)
+
+// VP8 frame tags, the first bit is the inverse keyframe flag
+var (
+	vp8KeyFrame   = []byte{0x10, 0x02, 0x00}
+	vp8InterFrame = []byte{0x11, 0x02, 0x00}
+)
+
+func ivfHeader(t *testing.T, path string) (image.Point, uint32) {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := image.Point{int(binary.LittleEndian.Uint16(data[12:])), int(binary.LittleEndian.Uint16(data[14:]))}
+	return size, binary.LittleEndian.Uint32(data[24:])
+}
+
+func TestSessionRecorderSizeChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	small, large := image.Point{640, 360}, image.Point{1280, 720}
+	recorder, err := newSessionRecorder(dir, "session", encoders.VP8Codec, small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := recorder.currentPath()
+
+	// Recordings start at a keyframe
+	recorder.write(vp8InterFrame, small)
+	recorder.write(vp8KeyFrame, small)
+	recorder.write(vp8InterFrame, small)
+	// The frames after the change wait for a keyframe too
+	recorder.write(vp8InterFrame, large)
+	recorder.write(vp8KeyFrame, large)
+	if err := recorder.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	second := recorder.currentPath()
+	if expected := filepath.Join(dir, "session-2.ivf"); second != expected {
+		t.Fatalf("The recording continued in %s, expected %s", second, expected)
+	}
+	if size, frames := ivfHeader(t, first); size != small || frames != 2 {
+		t.Errorf("The first part is %v with %d frames, expected %v with 2", size, frames, small)
+	}
+	if size, frames := ivfHeader(t, second); size != large || frames != 1 {
+		t.Errorf("The second part is %v with %d frames, expected %v with 1", size, frames, large)
+	}
+}
@@ -1,21 +1,51 @@
 package rtc
 
 import (
+	"image"
 	"io"
+	"time"
+
+	"github.com/pion/webrtc/v2"
 )
 
 type videoStreamer interface {
 	start()
+	replaceTrack(track *webrtc.Track, sender *webrtc.RTPSender)
+	switchBroadcaster(broadcaster *screenBroadcaster)
+	record(recorder *sessionRecorder) *sessionRecorder
+	videoSize() image.Point
+	frameStats() FrameStats
 	close()
 }
 
+// Signaler delivers server initiated offers to the client
+type Signaler interface {
+	SendOffer(offer string) error
+}
+
 // RemoteScreenConnection Represents a WebRTC connection to a single peer
 type RemoteScreenConnection interface {
 	io.Closer
-	ProcessOffer(offer string) (string, error)
+	ID() string
+	Info() SessionInfo
+	ProcessOffer(offer string, trickle bool) (string, error)
+	AddICECandidate(candidate ICECandidate) error
+	LocalCandidates(wait time.Duration) ([]ICECandidate, bool, error)
+	SetSignaler(signaler Signaler)
+	Renegotiate() error
+	AcceptAnswer(answer string) error
+	SwitchScreen(screenIx int) error
+	StartRecording() (string, error)
+	StopRecording() error
+	Done() <-chan struct{}
 }
 
 // Service WebRTC service
 type Service interface {
-	CreateRemoteScreenConnection(screenIx int, fps int) (RemoteScreenConnection, error)
+	CreateRemoteScreenConnection(screenIx int, options StreamOptions, remoteAddr string) (RemoteScreenConnection, error)
+	Sessions() []SessionInfo
+	Session(id string) (SessionInfo, error)
+	Connection(id string) (RemoteScreenConnection, error)
+	CloseSession(id string) error
+	ScreenFrames(screenIx int, fps int, stop <-chan struct{}) (<-chan *image.RGBA, error)
 }
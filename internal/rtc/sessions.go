@@ -0,0 +1,82 @@
+package rtc
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrSessionNotFound is returned when the session ID isn't registered
+var ErrSessionNotFound = errors.New("Session not found")
+
+// How long a session can stay without an ICE connection before it's terminated
+const sessionConnectTimeout = 30 * time.Second
+
+// A session whose video broadcast fails again within this interval of
+// being restarted is closed
+const broadcastRestartInterval = 10 * time.Second
+
+// SessionInfo describes a running session
+type SessionInfo struct {
+	ID         string
+	State      string
+	Codec      string
+	Screen     int
+	StartedAt  time.Time
+	RemoteAddr string
+	// Recording is the path of the file the session is being recorded to
+	Recording string
+	// Frames are the statistics of the broadcast the session is watching,
+	// shared with the other viewers of the same screen and settings
+	Frames FrameStats
+}
+
+// sessionRegistry keeps track of the running sessions keyed by ID
+type sessionRegistry struct {
+	mutex    sync.Mutex
+	sessions map[string]*RemoteScreenPeerConn
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{
+		sessions: make(map[string]*RemoteScreenPeerConn),
+	}
+}
+
+func (r *sessionRegistry) add(session *RemoteScreenPeerConn) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sessions[session.id] = session
+}
+
+func (r *sessionRegistry) remove(id string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.sessions, id)
+}
+
+func (r *sessionRegistry) get(id string) (*RemoteScreenPeerConn, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	session, found := r.sessions[id]
+	if !found {
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// list returns the info of every session, oldest first
+func (r *sessionRegistry) list() []SessionInfo {
+	r.mutex.Lock()
+	infos := make([]SessionInfo, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		infos = append(infos, session.Info())
+	}
+	r.mutex.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].StartedAt.Before(infos[j].StartedAt)
+	})
+	return infos
+}
@@ -1,76 +1,184 @@
 package rtc
 
 import (
-	"fmt"
 	"image"
+	"sync"
 
 	"github.com/nfnt/resize"
+	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v2"
-	"github.com/pion/webrtc/v2/pkg/media"
-	"github.com/rviscarra/webrtc-remote-screen/internal/encoders"
-	"github.com/rviscarra/webrtc-remote-screen/internal/rdisplay"
 )
 
+// Full Intra Request format, not parsed by pion/rtcp (RFC 5104, 4.3.1)
+const formatFIR uint8 = 4
+
 func resizeImage(src *image.RGBA, target image.Point) *image.RGBA {
 	return resize.Resize(uint(target.X), uint(target.Y), src, resize.Lanczos3).(*image.RGBA)
 }
 
+// cloneFrame copies a frame that must outlive the next one of its grabber
+func cloneFrame(frame *image.RGBA) *image.RGBA {
+	clone := *frame
+	clone.Pix = append([]byte(nil), frame.Pix...)
+	return &clone
+}
+
+// rtcStreamer subscribes a track to a shared screen broadcaster
 type rtcStreamer struct {
-	track   *webrtc.Track
-	stop    chan struct{}
-	screen  *rdisplay.ScreenGrabber
-	encoder *encoders.Encoder
-	size    image.Point
+	track       *webrtc.Track
+	sender      *webrtc.RTPSender
+	broadcaster *screenBroadcaster
+	registry    *broadcasterRegistry
+	maxBitrate  int
+	mutex       sync.Mutex
+	started     bool
+	recorder    *sessionRecorder
+	closed      bool
+	closeOnce   sync.Once
+	// onFailure is called when the broadcaster the track is subscribed to
+	// fails, it should switch the streamer to a new one
+	onFailure func()
 }
 
-func newRTCStreamer(track *webrtc.Track, screen *rdisplay.ScreenGrabber, encoder *encoders.Encoder, size image.Point) videoStreamer {
+func newRTCStreamer(track *webrtc.Track, sender *webrtc.RTPSender, broadcaster *screenBroadcaster, registry *broadcasterRegistry, maxBitrate int, onFailure func()) videoStreamer {
 	return &rtcStreamer{
-		track:   track,
-		stop:    make(chan struct{}),
-		screen:  screen,
-		encoder: encoder,
-		size:    size,
+		track:       track,
+		sender:      sender,
+		broadcaster: broadcaster,
+		registry:    registry,
+		maxBitrate:  maxBitrate,
+		onFailure:   onFailure,
 	}
 }
 
 func (s *rtcStreamer) start() {
-	go s.startStream()
+	s.mutex.Lock()
+	track, sender, broadcaster := s.track, s.sender, s.broadcaster
+	s.started = true
+	s.mutex.Unlock()
+	broadcaster.subscribe(track, s.maxBitrate)
+	go s.watch(broadcaster)
+	go s.readRTCP(track, sender)
 }
 
-func (s *rtcStreamer) startStream() {
-	screen := *s.screen
-	screen.Start()
-	frames := screen.Frames()
-	for {
-		select {
-		case <-s.stop:
-			screen.Stop()
-			return
-		case frame := <-frames:
-			err := s.stream(frame)
-			if err != nil {
-				fmt.Printf("Streamer: %v\n", err)
-				return
-			}
-		}
+// watch waits for the capture loop of the broadcaster to end, onFailure is
+// called if it failed while the track was still subscribed to it
+func (s *rtcStreamer) watch(broadcaster *screenBroadcaster) {
+	<-broadcaster.done
+	s.mutex.Lock()
+	current := s.broadcaster == broadcaster && !s.closed
+	s.mutex.Unlock()
+	if current && broadcaster.hasFailed() && s.onFailure != nil {
+		s.onFailure()
 	}
 }
 
-func (s *rtcStreamer) stream(frame *image.RGBA) error {
-	resized := resizeImage(frame, s.size)
-	payload, err := (*s.encoder).Encode(resized)
-	if err != nil {
-		return err
+func (s *rtcStreamer) currentBroadcaster() *screenBroadcaster {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.broadcaster
+}
+
+func (s *rtcStreamer) videoSize() image.Point {
+	return s.currentBroadcaster().videoSize
+}
+
+// frameStats returns the statistics of the broadcast the track is subscribed to
+func (s *rtcStreamer) frameStats() FrameStats {
+	return s.currentBroadcaster().frameStats()
+}
+
+// replaceTrack moves the subscription to the track of a renegotiated
+// peer connection
+func (s *rtcStreamer) replaceTrack(track *webrtc.Track, sender *webrtc.RTPSender) {
+	s.mutex.Lock()
+	previous := s.track
+	s.track, s.sender = track, sender
+	broadcaster := s.broadcaster
+	s.mutex.Unlock()
+	broadcaster.subscribe(track, s.maxBitrate)
+	broadcaster.unsubscribe(previous)
+	go s.readRTCP(track, sender)
+}
+
+// switchBroadcaster moves the track to another broadcaster, which must have
+// been acquired from the registry, and releases the current one. The track
+// leaves the old broadcast before joining the new one so their samples
+// never interleave, joining it forces a keyframe
+func (s *rtcStreamer) switchBroadcaster(broadcaster *screenBroadcaster) {
+	s.mutex.Lock()
+	previous := s.broadcaster
+	s.broadcaster = broadcaster
+	track, started, recorder := s.track, s.started, s.recorder
+	if recorder != nil {
+		previous.removeRecorder(recorder)
+		broadcaster.addRecorder(recorder)
 	}
-	if payload == nil {
-		return nil
+	s.mutex.Unlock()
+	if started {
+		previous.unsubscribe(track)
+		broadcaster.subscribe(track, s.maxBitrate)
+		go s.watch(broadcaster)
+	}
+	s.registry.release(previous)
+}
+
+// record feeds the recorder with the samples sent to the track, nil stops
+// the current recording. The previous recorder is returned so it can be closed
+func (s *rtcStreamer) record(recorder *sessionRecorder) *sessionRecorder {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	previous := s.recorder
+	if previous != nil {
+		s.broadcaster.removeRecorder(previous)
+	}
+	s.recorder = recorder
+	if recorder != nil {
+		s.broadcaster.addRecorder(recorder)
+	}
+	return previous
+}
+
+func isKeyFrameRequest(packet rtcp.Packet) bool {
+	switch p := packet.(type) {
+	case *rtcp.PictureLossIndication:
+		return true
+	case *rtcp.RawPacket:
+		header := p.Header()
+		return header.Type == rtcp.TypePayloadSpecificFeedback && header.Count == formatFIR
+	}
+	return false
+}
+
+// readRTCP processes the receiver feedback until the sender is closed
+func (s *rtcStreamer) readRTCP(track *webrtc.Track, sender *webrtc.RTPSender) {
+	for {
+		packets, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		broadcaster := s.currentBroadcaster()
+		for _, packet := range packets {
+			if isKeyFrameRequest(packet) {
+				broadcaster.requestKeyFrame()
+			} else {
+				broadcaster.rates.onFeedback(track, packet)
+			}
+		}
 	}
-	return s.track.WriteSample(media.Sample{
-		Data:    payload,
-		Samples: 1,
-	})
 }
 
 func (s *rtcStreamer) close() {
-	close(s.stop)
+	s.closeOnce.Do(func() {
+		s.mutex.Lock()
+		track, broadcaster, recorder := s.track, s.broadcaster, s.recorder
+		s.recorder = nil
+		s.closed = true
+		s.mutex.Unlock()
+		if recorder != nil {
+			broadcaster.removeRecorder(recorder)
+		}
+		broadcaster.unsubscribe(track)
+		s.registry.release(broadcaster)
+	})
 }
@@ -0,0 +1,77 @@
+package rtc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v2"
+)
+
+// ICECandidate is a trickled ICE candidate, in the format used by the
+// browser RTCIceCandidate
+type ICECandidate struct {
+	Candidate     string
+	SDPMid        string
+	SDPMLineIndex uint16
+}
+
+// candidateQueue buffers the local candidates until the client polls them
+type candidateQueue struct {
+	mutex   sync.Mutex
+	mid     string
+	pending []ICECandidate
+	done    bool
+	notify  chan struct{}
+}
+
+func newCandidateQueue(mid string) *candidateQueue {
+	return &candidateQueue{
+		mid:    mid,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// push is the webrtc.PeerConnection.OnICECandidate handler, a nil
+// candidate signals the end of the gathering
+func (q *candidateQueue) push(candidate *webrtc.ICECandidate) {
+	q.mutex.Lock()
+	if candidate == nil {
+		q.done = true
+	} else {
+		q.pending = append(q.pending, ICECandidate{
+			Candidate:     "candidate:" + candidate.ToJSON().Candidate,
+			SDPMid:        q.mid,
+			SDPMLineIndex: 0,
+		})
+	}
+	q.mutex.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *candidateQueue) drain() ([]ICECandidate, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	candidates := q.pending
+	q.pending = nil
+	return candidates, q.done
+}
+
+// poll returns the candidates gathered since the last call, waiting
+// up to wait for new ones if there are none yet
+func (q *candidateQueue) poll(wait time.Duration) ([]ICECandidate, bool) {
+	candidates, done := q.drain()
+	if len(candidates) > 0 || done {
+		return candidates, done
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-q.notify:
+	case <-timer.C:
+	}
+	return q.drain()
+}
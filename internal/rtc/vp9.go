@@ -0,0 +1,94 @@
+package rtc
+
+import (
+	"math/rand"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pion/webrtc/v2"
+	"github.com/rviscarra/webrtc-remote-screen/internal/encoders"
+	"github.com/rviscarra/webrtc-remote-screen/internal/recording"
+)
+
+// Size of the payload descriptor written by vp9Payloader
+const vp9HeaderSize = 3
+
+// vp9Payloader splits VP9 frames in RTP payloads, Pion doesn't ship one.
+// It uses the non-flexible mode without layers: every packet carries the
+// picture ID, and the P bit tells the receiver which frames are keyframes
+// (draft-ietf-payload-vp9, 4.2). The codec, and so the payloader, is shared
+// by the tracks of a session while it's renegotiated
+type vp9Payloader struct {
+	pictureID uint32
+}
+
+func newVP9Payloader() *vp9Payloader {
+	return &vp9Payloader{pictureID: uint32(rand.Intn(0x8000))}
+}
+
+// Payload fragments a VP9 frame across one or more payloads
+func (p *vp9Payloader) Payload(mtu int, payload []byte) [][]byte {
+	/*
+	 *       0 1 2 3 4 5 6 7
+	 *      +-+-+-+-+-+-+-+-+
+	 *      |I|P|L|F|B|E|V|-|
+	 *      +-+-+-+-+-+-+-+-+
+	 * I:   |M| PICTURE ID  |
+	 *      +-+-+-+-+-+-+-+-+
+	 *      | PICTURE ID    |
+	 *      +-+-+-+-+-+-+-+-+
+	 */
+	maxFragmentSize := mtu - vp9HeaderSize
+	if maxFragmentSize <= 0 || len(payload) == 0 {
+		return nil
+	}
+	flags := byte(0x80)
+	if !recording.IsKeyFrame(encoders.VP9Codec, payload) {
+		flags |= 0x40
+	}
+	pictureID := uint16(atomic.AddUint32(&p.pictureID, 1)) & 0x7fff
+	var payloads [][]byte
+	for offset := 0; offset < len(payload); offset += maxFragmentSize {
+		fragment := payload[offset:]
+		if len(fragment) > maxFragmentSize {
+			fragment = fragment[:maxFragmentSize]
+		}
+		out := make([]byte, vp9HeaderSize+len(fragment))
+		out[0] = flags
+		if offset == 0 {
+			out[0] |= 0x08
+		}
+		if offset+len(fragment) == len(payload) {
+			out[0] |= 0x04
+		}
+		out[1] = byte(pictureID>>8) | 0x80
+		out[2] = byte(pictureID)
+		copy(out[vp9HeaderSize:], fragment)
+		payloads = append(payloads, out)
+	}
+	return payloads
+}
+
+// vp9Profile returns the profile-id of a VP9 fmtp line, 0 if missing
+func vp9Profile(fmtp string) string {
+	return fmtpParam(fmtp, "profile-id", "0")
+}
+
+// fmtpParam returns a parameter of a fmtp line, or def if missing
+func fmtpParam(fmtp, name, def string) string {
+	for _, param := range strings.Split(fmtp, ";") {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(param, name+"=") {
+			return strings.TrimPrefix(param, name+"=")
+		}
+	}
+	return def
+}
+
+// newVP9Codec creates the codec with its payloader
+func newVP9Codec(payloadType uint8, clockRate uint32, fmtp string) *webrtc.RTPCodec {
+	codec := webrtc.NewRTPVP9Codec(payloadType, clockRate)
+	codec.SDPFmtpLine = fmtp
+	codec.Payloader = newVP9Payloader()
+	return codec
+}
@@ -0,0 +1,104 @@
+package tlsutil
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func tempFiles(t *testing.T) (string, string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "tlsutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), func() { os.RemoveAll(dir) }
+}
+
+func TestCreateSelfSigned(t *testing.T) {
+	certFile, keyFile, cleanup := tempFiles(t)
+	defer cleanup()
+	created, err := LoadOrCreateSelfSigned(certFile, keyFile, []string{"agent.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created.Certificate) == 0 {
+		t.Fatal("No certificate was created")
+	}
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("The key file wasn't written private: %v %v", info, err)
+	}
+
+	// The next start reloads the same pair
+	loaded, err := LoadOrCreateSelfSigned(certFile, keyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.Certificate[0], created.Certificate[0]) {
+		t.Error("The persisted certificate wasn't reloaded")
+	}
+}
+
+func TestLoadExistingPair(t *testing.T) {
+	certFile, keyFile, cleanup := tempFiles(t)
+	defer cleanup()
+	certPEM, keyPEM, err := generateSelfSigned(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(certFile, certPEM, 0644)
+	ioutil.WriteFile(keyFile, keyPEM, 0600)
+
+	if _, err := LoadOrCreateSelfSigned(certFile, keyFile, nil); err != nil {
+		t.Fatal(err)
+	}
+	for file, expected := range map[string][]byte{certFile: certPEM, keyFile: keyPEM} {
+		if data, _ := ioutil.ReadFile(file); !bytes.Equal(data, expected) {
+			t.Errorf("%s was modified", file)
+		}
+	}
+}
+
+func TestMissingHalfOfThePair(t *testing.T) {
+	certPEM, keyPEM, err := generateSelfSigned(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		present func(certFile, keyFile string) (string, []byte)
+		missing func(certFile, keyFile string) string
+	}{
+		{
+			"missing key",
+			func(certFile, keyFile string) (string, []byte) { return certFile, certPEM },
+			func(certFile, keyFile string) string { return keyFile },
+		},
+		{
+			"missing certificate",
+			func(certFile, keyFile string) (string, []byte) { return keyFile, keyPEM },
+			func(certFile, keyFile string) string { return certFile },
+		},
+	}
+	for _, test := range tests {
+		certFile, keyFile, cleanup := tempFiles(t)
+		present, data := test.present(certFile, keyFile)
+		missing := test.missing(certFile, keyFile)
+		ioutil.WriteFile(present, data, 0600)
+
+		_, err := LoadOrCreateSelfSigned(certFile, keyFile, nil)
+		if err == nil || !strings.Contains(err.Error(), missing) {
+			t.Errorf("%s: got error %v, expected one naming %s", test.name, err, missing)
+		}
+		if current, _ := ioutil.ReadFile(present); !bytes.Equal(current, data) {
+			t.Errorf("%s: %s was overwritten", test.name, present)
+		}
+		if _, err := os.Stat(missing); !os.IsNotExist(err) {
+			t.Errorf("%s: %s was created", test.name, missing)
+		}
+		cleanup()
+	}
+}
@@ -7,8 +7,66 @@ function showError(error) {
   errorNode.appendChild(document.createTextNode(error.message || error));
 }
 
+// Credentials passed in the page URL, either a bearer token (?token=...) or
+// a share link (?expires=...&signature=...). Basic auth is handled by the browser.
+const credentials = (() => {
+  const params = new URLSearchParams(window.location.search);
+  const stored = JSON.parse(window.sessionStorage.getItem('credentials') || '{}');
+  ['token', 'expires', 'signature'].forEach(name => {
+    if (params.has(name)) {
+      stored[name] = params.get(name);
+    }
+  });
+  window.sessionStorage.setItem('credentials', JSON.stringify(stored));
+  if (params.has('token') || params.has('signature')) {
+    // Don't leave the credentials in the address bar
+    window.history.replaceState(null, '', window.location.pathname);
+  }
+  return stored;
+})();
+
+// Video settings passed in the page URL (?fps=15&maxWidth=1280&maxHeight=720&bitrate=1500),
+// the agent picks its defaults for the missing ones. The cursor is drawn by
+// the page unless ?cursor=composite or ?cursor=none, ?chroma444 asks for
+// 4:4:4 video (VP9 only) and ?codecs=h264,vp8 sets the codec preference
+const streamOptions = (() => {
+  const params = new URLSearchParams(window.location.search);
+  const options = { cursor: params.get('cursor') || 'channel' };
+  if (params.has('chroma444')) {
+    options.chroma444 = true;
+  }
+  if (params.get('codecs')) {
+    options.codecs = params.get('codecs').split(',');
+  }
+  ['fps', 'maxWidth', 'maxHeight', 'bitrate'].forEach(name => {
+    const value = parseInt(params.get(name), 10);
+    if (!isNaN(value)) {
+      options[name] = value;
+    }
+  });
+  return options;
+})();
+
+function apiFetch(path, options) {
+  const url = new URL(path, window.location.href);
+  const headers = Object.assign({}, options.headers);
+  if (credentials.token) {
+    headers['Authorization'] = 'Bearer ' + credentials.token;
+  }
+  if (credentials.signature) {
+    url.searchParams.set('expires', credentials.expires);
+    url.searchParams.set('signature', credentials.signature);
+  }
+  return fetch(url.toString(), Object.assign({}, options, { headers })).then(res => {
+    if (res.status === 401) {
+      throw new Error('Unauthorized, check your credentials');
+    }
+    return res;
+  });
+}
+
 function loadScreens() {
-  return fetch('/api/screens', {
+  return apiFetch('/api/screens', {
     method: 'GET',
     headers: {
       'Accepts': 'application/json'
@@ -19,72 +77,336 @@ function loadScreens() {
 }
 
 function startSession(offer, screen) {
-  return fetch('/api/session', {
+  return apiFetch('/api/session', {
     method: 'POST',
-    body: JSON.stringify({
+    body: JSON.stringify(Object.assign({
       offer,
-      screen
-    }),
+      screen,
+      trickle: true
+    }, streamOptions)),
     headers: {
       'Content-Type': 'application/json'
     }
   }).then(res => {
+    if (!res.ok) {
+      // A rejected offer comes with the codecs the agent supports
+      return res.text().then(text => {
+        const body = text ? JSON.parse(text) : {};
+        const supported = body.supported ? ', the agent supports ' + body.supported.join(', ') : '';
+        throw new Error((body.error || 'Session creation failed: ' + res.status) + supported);
+      });
+    }
     return res.json();
-  }).then(msg => {
-    return msg.answer;
   });
 }
 
-function createOffer(pc, { audio, video }) {
+// Creates the offer without waiting for the ICE gathering, the local
+// candidates are queued in localCandidates until they can be trickled
+function createOffer(pc, { audio, video }, localCandidates) {
+  pc.onicecandidate = evt => {
+    // null signals the end of the gathering
+    localCandidates.push(evt.candidate ? evt.candidate.toJSON() : null);
+  };
+  return pc.createOffer({
+    offerToReceiveAudio: audio,
+    offerToReceiveVideo: video
+  }).then(ld => {
+    return pc.setLocalDescription(ld);
+  }).then(() => pc.localDescription.sdp);
+}
+
+// Exchanges ICE candidates with the agent until both sides are done gathering
+function trickleCandidates(pc, sessionId, localCandidates) {
+  let localDone = false;
+  const exchange = () => {
+    if (pc.signalingState === 'closed') {
+      return;
+    }
+    const candidates = localCandidates.splice(0).filter(c => {
+      localDone = localDone || c === null;
+      return c !== null;
+    });
+    return apiFetch('/api/session/' + sessionId, {
+      method: 'PATCH',
+      body: JSON.stringify({ candidates }),
+      headers: {
+        'Content-Type': 'application/json'
+      }
+    }).then(res => {
+      if (!res.ok) {
+        throw new Error('Candidate exchange failed: ' + res.status);
+      }
+      return res.json();
+    }).then(msg => {
+      return Promise.all(msg.candidates.map(c => pc.addIceCandidate(c))).then(() => msg.done);
+    }).then(remoteDone => {
+      if (remoteDone && localDone && localCandidates.length === 0) {
+        return;
+      }
+      // Once the agent is done it answers right away, don't hammer it
+      const delay = remoteDone ? 200 : 0;
+      return new Promise(accept => setTimeout(accept, delay)).then(exchange);
+    });
+  };
+  return exchange();
+}
+
+function attachInput(videoNode, channel) {
+  const send = (msg) => {
+    if (channel.readyState === 'open') {
+      channel.send(JSON.stringify(msg));
+    }
+  };
+
+  // Translates the event position into the encoded video coordinates
+  const position = (evt) => ({
+    x: Math.round(evt.offsetX * videoNode.videoWidth / videoNode.clientWidth),
+    y: Math.round(evt.offsetY * videoNode.videoHeight / videoNode.clientHeight)
+  });
+
+  const listeners = {
+    mousemove: evt => send(Object.assign({ type: 'mousemove' }, position(evt))),
+    mousedown: evt => {
+      videoNode.focus();
+      send(Object.assign({ type: 'mousedown', button: evt.button }, position(evt)));
+    },
+    mouseup: evt => send(Object.assign({ type: 'mouseup', button: evt.button }, position(evt))),
+    wheel: evt => {
+      evt.preventDefault();
+      send({ type: 'wheel', dx: Math.sign(evt.deltaX), dy: Math.sign(evt.deltaY) });
+    },
+    contextmenu: evt => evt.preventDefault(),
+    keydown: evt => {
+      evt.preventDefault();
+      send({ type: 'keydown', key: evt.key });
+    },
+    keyup: evt => {
+      evt.preventDefault();
+      send({ type: 'keyup', key: evt.key });
+    }
+  };
+
+  videoNode.setAttribute('tabindex', '0');
+  Object.keys(listeners).forEach(name => videoNode.addEventListener(name, listeners[name]));
+  return () => {
+    Object.keys(listeners).forEach(name => videoNode.removeEventListener(name, listeners[name]));
+    videoNode.removeAttribute('tabindex');
+  };
+}
+
+// The open input channel, it also carries the session control messages
+let controlChannel = null;
+
+function attachInputChannel(videoNode, channel) {
+  channel.onopen = () => {
+    const detachInput = attachInput(videoNode, channel);
+    controlChannel = channel;
+    channel.onclose = () => {
+      detachInput();
+      if (controlChannel === channel) {
+        controlChannel = null;
+      }
+    };
+  };
+}
+
+// Draws the remote cursor over the video, the updates are expressed in
+// the encoded video coordinates
+function attachCursorChannel(videoNode, cursorNode, channel) {
+  const shape = { x: 0, y: 0, width: 0, height: 0 };
+  channel.onmessage = evt => {
+    const msg = JSON.parse(evt.data);
+    if (!videoNode.videoWidth) {
+      return;
+    }
+    const scaleX = videoNode.clientWidth / videoNode.videoWidth;
+    const scaleY = videoNode.clientHeight / videoNode.videoHeight;
+    if (msg.type === 'shape') {
+      Object.assign(shape, { x: msg.x, y: msg.y, width: msg.width, height: msg.height });
+      cursorNode.src = msg.image;
+      cursorNode.style.width = (shape.width * scaleX) + 'px';
+      cursorNode.style.height = (shape.height * scaleY) + 'px';
+    } else if (msg.type === 'position') {
+      cursorNode.style.left = (videoNode.offsetLeft + (msg.x - shape.x) * scaleX) + 'px';
+      cursorNode.style.top = (videoNode.offsetTop + (msg.y - shape.y) * scaleY) + 'px';
+      cursorNode.style.setProperty('visibility', msg.visible ? 'visible' : 'hidden');
+    }
+  };
+  channel.onclose = () => cursorNode.style.setProperty('visibility', 'hidden');
+}
+
+function attachChannel(videoNode, channel) {
+  if (channel.label === 'cursor') {
+    attachCursorChannel(videoNode, document.querySelector('#remote-cursor'), channel);
+  } else {
+    attachInputChannel(videoNode, channel);
+  }
+}
+
+// Creates the data channels for a new session, the cursor one only
+// if the agent must send it
+function createChannels(pc, videoNode) {
+  attachInputChannel(videoNode, pc.createDataChannel('input'));
+  if (streamOptions.cursor === 'channel') {
+    attachChannel(videoNode, pc.createDataChannel('cursor'));
+  }
+}
+
+function newPeerConnection(remoteVideoNode) {
+  const pc = new RTCPeerConnection({
+    iceServers: [{ urls: 'stun:stun.l.google.com:19302' }]
+  });
+  pc.ontrack = (evt) => {
+    console.info('ontrack triggered');
+
+    // Audio and video share the stream, it only needs to be attached once
+    if (remoteVideoNode.srcObject !== evt.streams[0]) {
+      remoteVideoNode.srcObject = evt.streams[0];
+      remoteVideoNode.play();
+    }
+  };
+  return pc;
+}
+
+function signalingURL() {
+  const url = new URL('/api/ws', window.location.href);
+  url.protocol = url.protocol === 'https:' ? 'wss:' : 'ws:';
+  // Browsers can't set headers on WebSockets, the credentials go in the query
+  if (credentials.token) {
+    url.searchParams.set('token', credentials.token);
+  }
+  if (credentials.signature) {
+    url.searchParams.set('expires', credentials.expires);
+    url.searchParams.set('signature', credentials.signature);
+  }
+  return url.toString();
+}
+
+function openSignaling() {
   return new Promise((accept, reject) => {
+    const ws = new WebSocket(signalingURL());
+    ws.onopen = () => accept(ws);
+    ws.onerror = () => reject(new Error('Signaling channel unavailable'));
+  });
+}
+
+// Runs the session over the WebSocket signaling channel, the agent can send
+// new offers at any time, each one is answered with a new peer connection
+// that replaces the current one once connected
+function startSignaledSession(ws, screen, remoteVideoNode, stream) {
+  const session = {
+    pc: newPeerConnection(remoteVideoNode),
+    close: () => {
+      ws.close();
+      session.pc.close();
+    }
+  };
+  const send = msg => ws.send(JSON.stringify(msg));
+  let negotiating = session.pc;
+
+  const sendCandidates = pc => {
     pc.onicecandidate = evt => {
-      if (!evt.candidate) {
-        
-        // ICE Gathering finished 
-        const { sdp: offer } = pc.localDescription;
-        accept(offer);
+      if (evt.candidate) {
+        send({ type: 'candidate', candidate: evt.candidate.toJSON() });
       }
     };
-    pc.createOffer({
-      offerToReceiveAudio: audio,
-      offerToReceiveVideo: video
-    }).then(ld => {
-      pc.setLocalDescription(ld)
-    }).catch(reject)
+  };
+
+  const acceptOffer = sdp => {
+    const pc = newPeerConnection(remoteVideoNode);
+    pc.ondatachannel = evt => attachChannel(remoteVideoNode, evt.channel);
+    pc.oniceconnectionstatechange = () => {
+      if (pc.iceConnectionState === 'connected' && pc !== session.pc) {
+        const previous = session.pc;
+        session.pc = pc;
+        previous.close();
+      }
+    };
+    negotiating = pc;
+    sendCandidates(pc);
+    return pc.setRemoteDescription({ type: 'offer', sdp })
+      .then(() => pc.createAnswer())
+      .then(answer => pc.setLocalDescription(answer))
+      .then(() => send({ type: 'answer', sdp: pc.localDescription.sdp }));
+  };
+
+  return new Promise((accept, reject) => {
+    ws.onmessage = evt => {
+      const msg = JSON.parse(evt.data);
+      switch (msg.type) {
+        case 'answer':
+          session.id = msg.id;
+          session.pc.setRemoteDescription({ type: 'answer', sdp: msg.sdp })
+            .then(() => accept(session), reject);
+          break;
+        case 'offer':
+          acceptOffer(msg.sdp).catch(err => console.warn(err));
+          break;
+        case 'candidate':
+          negotiating.addIceCandidate(msg.candidate).catch(err => console.warn(err));
+          break;
+        case 'error':
+          console.warn(msg.error);
+          if (!session.id) {
+            reject(new Error(msg.error));
+          }
+          break;
+      }
+    };
+    ws.onclose = () => session.pc.close();
+
+    createChannels(session.pc, remoteVideoNode);
+    stream && stream.getTracks().forEach(track => {
+      session.pc.addTrack(track, stream);
+    });
+    sendCandidates(session.pc);
+    session.pc.createOffer({
+      offerToReceiveAudio: true,
+      offerToReceiveVideo: true
+    }).then(ld => session.pc.setLocalDescription(ld)).then(() => {
+      send(Object.assign({ type: 'offer', screen, sdp: session.pc.localDescription.sdp }, streamOptions));
+    }).catch(reject);
   });
 }
 
-function startRemoteSession(screen, remoteVideoNode, stream) {
+// Fallback for proxies that don't let WebSockets thru, the session
+// can't be renegotiated
+function startHTTPSession(screen, remoteVideoNode, stream) {
   let pc;
+  const localCandidates = [];
 
   return Promise.resolve().then(() => {
-    pc = new RTCPeerConnection({
-      iceServers: [{ urls: 'stun:stun.l.google.com:19302' }]
-    });
-    pc.ontrack = (evt) => {
-      console.info('ontrack triggered');
-      
-      remoteVideoNode.srcObject = evt.streams[0];
-      remoteVideoNode.play();
-    };
+    pc = newPeerConnection(remoteVideoNode);
+    createChannels(pc, remoteVideoNode);
 
     stream && stream.getTracks().forEach(track => {
       pc.addTrack(track, stream);
     })
-    return createOffer(pc, { audio: false, video: true });
+    return createOffer(pc, { audio: true, video: true }, localCandidates);
   }).then(offer => {
     console.info(offer);
     return startSession(offer, screen);
-  }).then(answer => {
+  }).then(({ id, answer }) => {
     console.info(answer);
     return pc.setRemoteDescription(new RTCSessionDescription({
       sdp: answer,
       type: 'answer'
-    }));
-  }).then(() => pc);
+    })).then(() => {
+      trickleCandidates(pc, id, localCandidates).catch(err => console.warn(err));
+    });
+  }).then(() => ({ pc, close: () => pc.close() }));
 }
 
-let peerConnection = null;
+function startRemoteSession(screen, remoteVideoNode, stream) {
+  return openSignaling().then(
+    ws => startSignaledSession(ws, screen, remoteVideoNode, stream),
+    err => {
+      console.warn(err);
+      return startHTTPSession(screen, remoteVideoNode, stream);
+    });
+}
+
+let remoteSession = null;
 document.addEventListener('DOMContentLoaded', () => {
   
   let selectedScreen = 0;
@@ -99,7 +421,9 @@ document.addEventListener('DOMContentLoaded', () => {
     screenSelect.appendChild(document.createElement('option'));
     response.screens.forEach(screen => {
       const option = document.createElement('option');
-      option.appendChild(document.createTextNode('Screen ' + (screen.index + 1)));
+      const name = screen.name || ('Screen ' + (screen.index + 1));
+      const label = name + ' (' + screen.width + 'x' + screen.height + ')' + (screen.primary ? ' - primary' : '');
+      option.appendChild(document.createTextNode(label));
       option.setAttribute('value', screen.index);
       screenSelect.appendChild(option);
     });
@@ -107,6 +431,10 @@ document.addEventListener('DOMContentLoaded', () => {
 
   screenSelect.addEventListener('change', evt => {
     selectedScreen = parseInt(evt.currentTarget.value, 10);
+    // Running sessions switch screens without reconnecting
+    if (remoteSession && controlChannel && !isNaN(selectedScreen)) {
+      controlChannel.send(JSON.stringify({ type: 'screen', screen: selectedScreen }));
+    }
   });
 
   const enableStartStop = (enabled) => {
@@ -128,19 +456,19 @@ document.addEventListener('DOMContentLoaded', () => {
     const userMediaPromise =  (adapter.browserDetails.browser === 'safari') ?
       navigator.mediaDevices.getUserMedia({ video: true }) : 
       Promise.resolve(null);
-    if (!peerConnection) {
+    if (!remoteSession) {
       userMediaPromise.then(stream => {
-        return startRemoteSession(selectedScreen, remoteVideo, stream).then(pc => {
+        return startRemoteSession(selectedScreen, remoteVideo, stream).then(session => {
           remoteVideo.style.setProperty('visibility', 'visible');
-          peerConnection = pc;
+          remoteSession = session;
         }).catch(showError).then(() => {
           enableStartStop(true);
           setStartStopTitle('Stop');
         });
       })
     } else {
-      peerConnection.close();
-      peerConnection = null;
+      remoteSession.close();
+      remoteSession = null;
       enableStartStop(true);
       setStartStopTitle('Start');
       remoteVideo.style.setProperty('visibility', 'collapse');
@@ -149,7 +477,7 @@ document.addEventListener('DOMContentLoaded', () => {
 });
 
 window.addEventListener('beforeunload', () => {
-  if (peerConnection) {
-    peerConnection.close();
+  if (remoteSession) {
+    remoteSession.close();
   }
 })